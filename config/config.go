@@ -3,6 +3,10 @@ package config
 import (
 	"errors"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds data required to start the application
@@ -15,6 +19,95 @@ type Config struct {
 	PythonScriptPath string
 	TempDir          string
 	SMTP             SMTPData
+	Mail             MailData
+	// AppURL is a public address of the client application used to build links sent in emails, may be empty
+	AppURL string
+	// RequireVerifiedEmail denies unverified users access to the ege and chat services
+	RequireVerifiedEmail bool
+	// OAuthProviders maps a provider name used in routes to its client registration
+	OAuthProviders map[string]OAuthProviderData
+	PasswordPolicy PasswordPolicyData
+	PasswordHash   PasswordHashData
+	// AccountDeletionGracePeriod is how long a deleted account can still be restored before it's purged
+	AccountDeletionGracePeriod time.Duration
+	// AdminEmails lists users who are given the admin role on start
+	AdminEmails []string
+	JWTKeys     JWTKeysData
+	// InviteOnly makes signups require an invite code
+	InviteOnly bool
+	// IntrospectionClients maps ids of backends allowed to introspect tokens to their secrets
+	IntrospectionClients map[string]string
+	ProofOfWork          ProofOfWorkData
+}
+
+// ProofOfWorkData struct provides settings of challenges required by routes bots tend to abuse
+type ProofOfWorkData struct {
+	// Enabled makes signups, restore emails and magic links require a solved challenge, it's set by POW_ENABLED.
+	// It's opt-in while clients don't solve challenges yet since every such request would be rejected until then:
+	// deploy clients fetching GET /api/auth/challenge first, then set POW_ENABLED=true. A warning is logged on start
+	// while it's off, so signups and restore emails aren't protected from bots silently
+	Enabled bool
+	// BaseDifficulty is a number of leading zero bits a solution hash needs under normal load
+	BaseDifficulty int
+	// MaxDifficulty caps the difficulty raised under heavy load
+	MaxDifficulty int
+}
+
+// JWTKeysData struct provides PEM files of asymmetric keys tokens are signed with. To rotate a key, add the new one
+// to verification keys first so it gets published, then make it the signing key and keep the old one
+// as a verification key until tokens signed with it expire
+type JWTKeysData struct {
+	// SigningKeyFile is a private RSA or Ed25519 key, tokens are signed with the secret key if it's empty
+	// and tokens signed with the secret key are rejected if it's not
+	SigningKeyFile string
+	// VerificationKeyFiles are public or private keys tokens are still or already accepted with
+	VerificationKeyFiles []string
+	// LegacyTokensIssuedBefore is when tokens identifying users by email stopped being issued, such tokens
+	// issued earlier are accepted until they expire. It must be set to the time of the deploy that switched
+	// to user ids, such tokens are rejected if it's zero
+	LegacyTokensIssuedBefore time.Time
+	// HS256AcceptedUntil is when tokens signed with the secret key stop being accepted after switching to a signing key,
+	// it should be at least the lifespan of access tokens after the switch. They are rejected right away if it's zero
+	HS256AcceptedUntil time.Time
+}
+
+// PasswordPolicyData struct provides rules every user password must follow
+type PasswordPolicyData struct {
+	MinLength int
+	// MaxLength is measured in bytes, it only guards against hashing huge inputs since argon2id has no length limit
+	MaxLength        int
+	CharacterClasses int
+	// BreachedListPath is a file with passwords known from data breaches, may be empty
+	BreachedListPath string
+}
+
+// PasswordHashData struct provides argon2id cost parameters, memory is measured in KiB
+type PasswordHashData struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// OAuthProviderData struct provides data required to sign in through an OpenID Connect provider
+type OAuthProviderData struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// MailData struct provides the way emails are delivered
+type MailData struct {
+	// Transport is one of smtp, file or memory, SMTP data is required only for smtp
+	Transport string
+	// Dir is where the file transport writes messages to
+	Dir string
+	// From is the sender address, defaults to the SMTP mail
+	From string
+	// Workers is a number of goroutines delivering queued messages
+	Workers int
+	// MaxAttempts is a number of delivery attempts after which a message is dead-lettered
+	MaxAttempts int
 }
 
 // SMTPData struct provides data required to send emails
@@ -56,21 +149,76 @@ func Get() (*Config, error) {
 		return nil, errors.New("no temporary dir path provided")
 	}
 	_ = os.MkdirAll(tempDir, 0770) // create if not exists
-	smtpMail := os.Getenv("SMTP_MAIL")
-	if smtpMail == "" {
-		return nil, errors.New("no smtp mail provided")
+	mailData, err := getMail(tempDir)
+	if err != nil {
+		return nil, err
 	}
+	smtpMail := os.Getenv("SMTP_MAIL")
 	smtpPassword := os.Getenv("SMTP_PASSWORD")
-	if smtpPassword == "" {
-		return nil, errors.New("no smtp password provided")
-	}
 	smtpHost := os.Getenv("SMTP_HOST")
-	if smtpHost == "" {
-		return nil, errors.New("no smtp host provided")
-	}
 	smtpPort := os.Getenv("SMTP_PORT")
-	if smtpPort == "" {
-		return nil, errors.New("no smtp port provided")
+	if mailData.Transport == "smtp" {
+		if smtpMail == "" {
+			return nil, errors.New("no smtp mail provided")
+		}
+		if smtpPassword == "" {
+			return nil, errors.New("no smtp password provided")
+		}
+		if smtpHost == "" {
+			return nil, errors.New("no smtp host provided")
+		}
+		if smtpPort == "" {
+			return nil, errors.New("no smtp port provided")
+		}
+	}
+	if mailData.From == "" {
+		mailData.From = smtpMail
+	}
+	appURL := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	requireVerifiedEmail, err := getEnvBool("REQUIRE_VERIFIED_EMAIL", false)
+	if err != nil {
+		return nil, err
+	}
+	oauthProviders, err := getOAuthProviders()
+	if err != nil {
+		return nil, err
+	}
+	passwordPolicy, err := getPasswordPolicy()
+	if err != nil {
+		return nil, err
+	}
+	passwordHash, err := getPasswordHash()
+	if err != nil {
+		return nil, err
+	}
+	accountDeletionGracePeriod, err := getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", time.Hour*24*7)
+	if err != nil {
+		return nil, err
+	}
+	adminEmails := getEnvList("ADMIN_EMAILS")
+	inviteOnly, err := getEnvBool("INVITE_ONLY", false)
+	if err != nil {
+		return nil, err
+	}
+	introspectionClients, err := getIntrospectionClients()
+	if err != nil {
+		return nil, err
+	}
+	var legacyTokensIssuedBefore time.Time
+	if value := os.Getenv("LEGACY_TOKENS_ISSUED_BEFORE"); value != "" {
+		if legacyTokensIssuedBefore, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, errors.New("invalid LEGACY_TOKENS_ISSUED_BEFORE value provided")
+		}
+	}
+	var hs256AcceptedUntil time.Time
+	if value := os.Getenv("HS256_ACCEPTED_UNTIL"); value != "" {
+		if hs256AcceptedUntil, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, errors.New("invalid HS256_ACCEPTED_UNTIL value provided")
+		}
+	}
+	proofOfWork, err := getProofOfWork()
+	if err != nil {
+		return nil, err
 	}
 
 	return &Config{
@@ -85,5 +233,230 @@ func Get() (*Config, error) {
 			Host:     smtpHost,
 			Port:     smtpPort,
 		},
+		Mail:                       *mailData,
+		AppURL:                     appURL,
+		RequireVerifiedEmail:       requireVerifiedEmail,
+		OAuthProviders:             oauthProviders,
+		PasswordPolicy:             *passwordPolicy,
+		PasswordHash:               *passwordHash,
+		AccountDeletionGracePeriod: accountDeletionGracePeriod,
+		AdminEmails:                adminEmails,
+		JWTKeys: JWTKeysData{
+			SigningKeyFile:           os.Getenv("JWT_SIGNING_KEY"),
+			VerificationKeyFiles:     getEnvList("JWT_VERIFICATION_KEYS"),
+			LegacyTokensIssuedBefore: legacyTokensIssuedBefore,
+			HS256AcceptedUntil:       hs256AcceptedUntil,
+		},
+		InviteOnly:           inviteOnly,
+		IntrospectionClients: introspectionClients,
+		ProofOfWork:          *proofOfWork,
 	}, nil
 }
+
+func getPasswordPolicy() (*PasswordPolicyData, error) {
+	minLength, err := getEnvInt("PASSWORD_MIN_LENGTH", 8)
+	if err != nil {
+		return nil, err
+	}
+	maxLength, err := getEnvInt("PASSWORD_MAX_LENGTH", 128)
+	if err != nil {
+		return nil, err
+	}
+	if maxLength > 1024 {
+		return nil, errors.New("password max length can't exceed 1024 bytes")
+	}
+	if minLength < 1 || minLength > maxLength {
+		return nil, errors.New("invalid password min length provided")
+	}
+	characterClasses, err := getEnvInt("PASSWORD_CHARACTER_CLASSES", 0)
+	if err != nil {
+		return nil, err
+	}
+	if characterClasses < 0 || characterClasses > 4 {
+		return nil, errors.New("password character classes must be between 0 and 4")
+	}
+	return &PasswordPolicyData{
+		MinLength:        minLength,
+		MaxLength:        maxLength,
+		CharacterClasses: characterClasses,
+		BreachedListPath: os.Getenv("PASSWORD_BREACHED_LIST"),
+	}, nil
+}
+
+func getProofOfWork() (*ProofOfWorkData, error) {
+	enabled, err := getEnvBool("POW_ENABLED", false)
+	if err != nil {
+		return nil, err
+	}
+	baseDifficulty, err := getEnvInt("POW_BASE_DIFFICULTY", 16)
+	if err != nil {
+		return nil, err
+	}
+	maxDifficulty, err := getEnvInt("POW_MAX_DIFFICULTY", 24)
+	if err != nil {
+		return nil, err
+	}
+	if baseDifficulty < 0 || maxDifficulty > 32 || baseDifficulty > maxDifficulty {
+		return nil, errors.New("proof of work difficulty must be between 0 and 32 bits, the base one can't exceed the max one")
+	}
+	return &ProofOfWorkData{
+		Enabled:        enabled,
+		BaseDifficulty: baseDifficulty,
+		MaxDifficulty:  maxDifficulty,
+	}, nil
+}
+
+func getMail(tempDir string) (*MailData, error) {
+	transport := os.Getenv("MAIL_TRANSPORT")
+	if transport == "" {
+		transport = "smtp"
+	}
+	if transport != "smtp" && transport != "file" && transport != "memory" {
+		return nil, errors.New("mail transport must be one of smtp, file or memory")
+	}
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		dir = filepath.Join(tempDir, "mail")
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" && transport != "smtp" {
+		from = "fetchapp@localhost"
+	}
+	workers, err := getEnvInt("MAIL_WORKERS", 4)
+	if err != nil {
+		return nil, err
+	}
+	if workers < 1 || workers > 64 {
+		return nil, errors.New("mail workers must be between 1 and 64")
+	}
+	maxAttempts, err := getEnvInt("MAIL_MAX_ATTEMPTS", 8)
+	if err != nil {
+		return nil, err
+	}
+	if maxAttempts < 1 {
+		return nil, errors.New("mail max attempts must be positive")
+	}
+	return &MailData{
+		Transport:   transport,
+		Dir:         dir,
+		From:        from,
+		Workers:     workers,
+		MaxAttempts: maxAttempts,
+	}, nil
+}
+
+func getPasswordHash() (*PasswordHashData, error) {
+	memory, err := getEnvInt("ARGON2_MEMORY", 64*1024)
+	if err != nil {
+		return nil, err
+	}
+	if memory < 8*1024 || memory > 4*1024*1024 {
+		return nil, errors.New("argon2 memory must be between 8192 and 4194304 KiB")
+	}
+	iterations, err := getEnvInt("ARGON2_ITERATIONS", 3)
+	if err != nil {
+		return nil, err
+	}
+	if iterations < 1 || iterations > 100 {
+		return nil, errors.New("argon2 iterations must be between 1 and 100")
+	}
+	parallelism, err := getEnvInt("ARGON2_PARALLELISM", 2)
+	if err != nil {
+		return nil, err
+	}
+	if parallelism < 1 || parallelism > 255 {
+		return nil, errors.New("argon2 parallelism must be between 1 and 255")
+	}
+	return &PasswordHashData{
+		Memory:      uint32(memory),
+		Iterations:  uint32(iterations),
+		Parallelism: uint8(parallelism),
+	}, nil
+}
+
+// getOAuthProviders reads providers listed in OAUTH_PROVIDERS, each of them is configured with
+// OAUTH_<NAME>_ISSUER, OAUTH_<NAME>_CLIENT_ID, OAUTH_<NAME>_CLIENT_SECRET and OAUTH_<NAME>_REDIRECT_URL
+func getOAuthProviders() (map[string]OAuthProviderData, error) {
+	providers := make(map[string]OAuthProviderData)
+	for _, name := range strings.Split(os.Getenv("OAUTH_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OAUTH_" + strings.ToUpper(name) + "_"
+		provider := OAuthProviderData{
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			return nil, errors.New("incomplete configuration of the " + name + " oauth provider")
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// getIntrospectionClients parses a comma separated list of id:secret pairs
+func getIntrospectionClients() (map[string]string, error) {
+	clients := make(map[string]string)
+	for _, item := range getEnvList("INTROSPECTION_CLIENTS") {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || len(parts[1]) < 16 {
+			return nil, errors.New("introspection clients must be id:secret pairs with secrets of at least 16 characters")
+		}
+		clients[parts[0]] = parts[1]
+	}
+	return clients, nil
+}
+
+// getEnvInt parses an optional integer environment variable
+func getEnvInt(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, errors.New("invalid " + key + " value provided")
+	}
+	return parsed, nil
+}
+
+// getEnvBool parses an optional boolean environment variable
+func getEnvBool(key string, fallback bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("invalid " + key + " value provided")
+	}
+	return parsed, nil
+}
+
+// getEnvDuration parses an optional duration environment variable like "72h"
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, errors.New("invalid " + key + " value provided")
+	}
+	return parsed, nil
+}
+
+// getEnvList parses an optional comma separated environment variable skipping empty items
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
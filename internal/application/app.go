@@ -3,15 +3,26 @@ package application
 import (
 	"database/sql"
 	"log"
+	"os"
+	"path/filepath"
 
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/adjsky/fetchapp_server/internal/models/outbox"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/password"
+	"github.com/adjsky/fetchapp_server/internal/models/user/policy"
 	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
 
 	"github.com/adjsky/fetchapp_server/config"
 	"github.com/adjsky/fetchapp_server/internal/services"
+	"github.com/adjsky/fetchapp_server/internal/services/admin"
 	"github.com/adjsky/fetchapp_server/internal/services/auth"
 	"github.com/adjsky/fetchapp_server/internal/services/chat"
 	"github.com/adjsky/fetchapp_server/internal/services/ege"
+	"github.com/adjsky/fetchapp_server/internal/services/invites"
+	"github.com/adjsky/fetchapp_server/internal/services/users"
 	"github.com/adjsky/fetchapp_server/pkg/handlers"
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
 	"github.com/adjsky/fetchapp_server/pkg/middlewares"
 	"github.com/gin-gonic/gin"
 
@@ -25,13 +36,223 @@ const (
 		"email VARCHAR(100) NOT NULL UNIQUE," +
 		"password VARCHAR(100) NOT NULL," +
 		"created_at TIMESTAMP NOT NULL DEFAULT NOW());"
+	// accounts created before email verification was introduced are considered verified
+	emailVerifiedScheme = "ALTER TABLE Users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT TRUE;" +
+		"ALTER TABLE Users ALTER COLUMN email_verified SET DEFAULT FALSE;"
+	refreshTokensScheme = "CREATE TABLE IF NOT EXISTS RefreshTokens (" +
+		"ID SERIAL PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"family VARCHAR(32) NOT NULL," +
+		"token_hash CHAR(64) NOT NULL UNIQUE," +
+		"used BOOLEAN NOT NULL DEFAULT FALSE," +
+		"revoked BOOLEAN NOT NULL DEFAULT FALSE," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());" +
+		"CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON RefreshTokens (family);"
+	restoreCodesScheme = "CREATE TABLE IF NOT EXISTS RestoreCodes (" +
+		"ID SERIAL PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"code_hash CHAR(64) NOT NULL," +
+		"attempts INTEGER NOT NULL DEFAULT 0," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"used_at TIMESTAMPTZ," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());"
+	totpScheme = "ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;" +
+		"CREATE TABLE IF NOT EXISTS RecoveryCodes (" +
+		"ID SERIAL PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"code_hash CHAR(64) NOT NULL," +
+		"used_at TIMESTAMPTZ);"
+	identitiesScheme = "CREATE TABLE IF NOT EXISTS Identities (" +
+		"ID SERIAL PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"provider VARCHAR(50) NOT NULL," +
+		"subject VARCHAR(255) NOT NULL," +
+		"email VARCHAR(100)," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"UNIQUE (provider, subject));" +
+		"CREATE TABLE IF NOT EXISTS OAuthStates (" +
+		"state VARCHAR(64) PRIMARY KEY," +
+		"provider VARCHAR(50) NOT NULL," +
+		"code_verifier VARCHAR(128) NOT NULL," +
+		"nonce VARCHAR(64) NOT NULL," +
+		"expires_at TIMESTAMPTZ NOT NULL);"
+	authFailuresScheme = "CREATE TABLE IF NOT EXISTS AuthFailures (" +
+		"key VARCHAR(150) PRIMARY KEY," +
+		"failures INTEGER NOT NULL DEFAULT 0," +
+		"locked_until TIMESTAMPTZ," +
+		"updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW());"
+	sessionsScheme = "CREATE TABLE IF NOT EXISTS Sessions (" +
+		"ID VARCHAR(32) PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"device VARCHAR(100) NOT NULL DEFAULT ''," +
+		"user_agent VARCHAR(255) NOT NULL DEFAULT ''," +
+		"ip VARCHAR(45) NOT NULL DEFAULT ''," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"revoked_at TIMESTAMPTZ);"
+	accountDeletionScheme = "ALTER TABLE Users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ;"
+	rolesScheme           = "ALTER TABLE Users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'student';" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMPTZ;" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE;" +
+		// accounts created through a provider can't change the password, so the flag would lock them out
+		"UPDATE Users SET password_reset_required = FALSE WHERE password = '!' AND password_reset_required;"
+	apiKeysScheme = "CREATE TABLE IF NOT EXISTS APIKeys (" +
+		"ID SERIAL PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"name VARCHAR(100) NOT NULL DEFAULT ''," +
+		"hint VARCHAR(16) NOT NULL," +
+		"key_hash CHAR(64) NOT NULL UNIQUE," +
+		"scopes TEXT[] NOT NULL DEFAULT '{}'," +
+		"expires_at TIMESTAMPTZ," +
+		"last_used_at TIMESTAMPTZ," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"revoked_at TIMESTAMPTZ);"
+	magicLinksScheme = "CREATE TABLE IF NOT EXISTS MagicLinks (" +
+		"ID VARCHAR(32) PRIMARY KEY," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"used_at TIMESTAMPTZ);"
+	emailChangesScheme = "CREATE TABLE IF NOT EXISTS EmailChanges (" +
+		"ID VARCHAR(32) PRIMARY KEY," +
+		"user_id INTEGER NOT NULL UNIQUE REFERENCES Users (ID) ON DELETE CASCADE," +
+		"new_email VARCHAR(100) NOT NULL," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());"
+	// argon2id PHC strings are longer than bcrypt hashes
+	passwordHashScheme = "ALTER TABLE Users ALTER COLUMN password TYPE VARCHAR(255);"
+	emailOutboxScheme  = "CREATE TABLE IF NOT EXISTS EmailOutbox (" +
+		"ID SERIAL PRIMARY KEY," +
+		"sender VARCHAR(320) NOT NULL," +
+		"recipients TEXT[] NOT NULL," +
+		"subject TEXT NOT NULL," +
+		"text_body TEXT NOT NULL," +
+		"html_body TEXT NOT NULL DEFAULT ''," +
+		"status VARCHAR(10) NOT NULL DEFAULT 'pending'," +
+		"attempts INTEGER NOT NULL DEFAULT 0," +
+		"last_error TEXT NOT NULL DEFAULT ''," +
+		"next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"locked_until TIMESTAMPTZ," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"sent_at TIMESTAMPTZ);" +
+		"CREATE INDEX IF NOT EXISTS email_outbox_due_idx ON EmailOutbox (status, next_attempt_at);" +
+		// messages queued before the column was added may carry codes or links, so their bodies are erased
+		"ALTER TABLE EmailOutbox ADD COLUMN IF NOT EXISTS sensitive BOOLEAN NOT NULL DEFAULT TRUE;" +
+		"UPDATE EmailOutbox SET text_body = '', html_body = '' " +
+		"WHERE (status = 'sent' OR (status = 'dead' AND sensitive)) AND (text_body <> '' OR html_body <> '');"
+	// audit entries outlive accounts, so user_id isn't a foreign key, and the trigger keeps the log append-only
+	auditLogScheme = "CREATE TABLE IF NOT EXISTS AuditLog (" +
+		"ID BIGSERIAL PRIMARY KEY," +
+		"event VARCHAR(40) NOT NULL," +
+		"user_id INTEGER," +
+		"email VARCHAR(100) NOT NULL DEFAULT ''," +
+		"actor VARCHAR(100) NOT NULL DEFAULT ''," +
+		"ip VARCHAR(45) NOT NULL DEFAULT ''," +
+		"user_agent TEXT NOT NULL DEFAULT ''," +
+		"details TEXT NOT NULL DEFAULT ''," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());" +
+		"CREATE INDEX IF NOT EXISTS audit_log_user_idx ON AuditLog (user_id);" +
+		"CREATE INDEX IF NOT EXISTS audit_log_created_idx ON AuditLog (created_at);" +
+		// updates are let through only for erasure of personal data of deleted accounts, see audit.Erase
+		"CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS TRIGGER AS $$ " +
+		"BEGIN IF TG_OP = 'UPDATE' AND current_setting('audit_log.erasure', TRUE) = 'on' THEN RETURN NEW; END IF; " +
+		"RAISE EXCEPTION 'the audit log is append-only'; END; $$ LANGUAGE plpgsql;" +
+		"DROP TRIGGER IF EXISTS audit_log_append_only ON AuditLog;" +
+		"CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON AuditLog " +
+		"FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only();"
+	// revoked tokens are kept until they expire on their own
+	tokenRevocationScheme = "CREATE TABLE IF NOT EXISTS RevokedTokens (" +
+		"jti VARCHAR(64) PRIMARY KEY," +
+		"expires_at TIMESTAMPTZ NOT NULL);" +
+		"CREATE INDEX IF NOT EXISTS revoked_tokens_expires_idx ON RevokedTokens (expires_at);" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS tokens_valid_after TIMESTAMPTZ;"
+	invitesScheme = "CREATE TABLE IF NOT EXISTS Groups (" +
+		"ID SERIAL PRIMARY KEY," +
+		"name VARCHAR(100) NOT NULL UNIQUE," +
+		"created_by INTEGER REFERENCES Users (ID) ON DELETE SET NULL," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());" +
+		"CREATE TABLE IF NOT EXISTS GroupMembers (" +
+		"group_id INTEGER NOT NULL REFERENCES Groups (ID) ON DELETE CASCADE," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"joined_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"PRIMARY KEY (group_id, user_id));" +
+		"CREATE TABLE IF NOT EXISTS Invites (" +
+		"ID SERIAL PRIMARY KEY," +
+		"code_hash CHAR(64) NOT NULL UNIQUE," +
+		"hint VARCHAR(16) NOT NULL," +
+		"created_by INTEGER REFERENCES Users (ID) ON DELETE SET NULL," +
+		"email VARCHAR(100) NOT NULL DEFAULT ''," +
+		"role VARCHAR(20) NOT NULL DEFAULT 'student'," +
+		"group_id INTEGER REFERENCES Groups (ID) ON DELETE SET NULL," +
+		"max_uses INTEGER NOT NULL DEFAULT 1," +
+		"uses INTEGER NOT NULL DEFAULT 0," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()," +
+		"revoked_at TIMESTAMPTZ);" +
+		"CREATE TABLE IF NOT EXISTS InviteRedemptions (" +
+		"ID SERIAL PRIMARY KEY," +
+		"invite_id INTEGER NOT NULL REFERENCES Invites (ID) ON DELETE CASCADE," +
+		"user_id INTEGER NOT NULL REFERENCES Users (ID) ON DELETE CASCADE," +
+		"redeemed_at TIMESTAMPTZ NOT NULL DEFAULT NOW());"
+	// spent challenges are kept until they expire so a solution can't be replayed
+	spentChallengesScheme = "CREATE TABLE IF NOT EXISTS SpentChallenges (" +
+		"nonce VARCHAR(32) PRIMARY KEY," +
+		"ip VARCHAR(45) NOT NULL DEFAULT ''," +
+		"expires_at TIMESTAMPTZ NOT NULL," +
+		"spent_at TIMESTAMPTZ NOT NULL DEFAULT NOW());" +
+		"CREATE INDEX IF NOT EXISTS spent_challenges_spent_idx ON SpentChallenges (spent_at);"
+	// avatars are kept in the database since instances don't share a disk, they replace files named in Users.avatar
+	avatarsScheme = "CREATE TABLE IF NOT EXISTS Avatars (" +
+		"user_id INTEGER PRIMARY KEY REFERENCES Users (ID) ON DELETE CASCADE," +
+		"name VARCHAR(64) NOT NULL," +
+		"data BYTEA NOT NULL," +
+		"created_at TIMESTAMPTZ NOT NULL DEFAULT NOW());" +
+		"ALTER TABLE Users DROP COLUMN IF EXISTS avatar;"
+	profileScheme = "ALTER TABLE Users ADD COLUMN IF NOT EXISTS display_name VARCHAR(50) NOT NULL DEFAULT '';" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS locale VARCHAR(10) NOT NULL DEFAULT 'en';" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS time_zone VARCHAR(64) NOT NULL DEFAULT 'UTC';" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS exam_year INTEGER;" +
+		"ALTER TABLE Users ADD COLUMN IF NOT EXISTS last_login_at TIMESTAMPTZ;"
 )
 
+// migrationSchemes are applied in order on every start, so each of them must be idempotent
+var migrationSchemes = []string{
+	migrationScheme,
+	emailVerifiedScheme,
+	refreshTokensScheme,
+	restoreCodesScheme,
+	totpScheme,
+	identitiesScheme,
+	authFailuresScheme,
+	sessionsScheme,
+	accountDeletionScheme,
+	rolesScheme,
+	apiKeysScheme,
+	magicLinksScheme,
+	emailChangesScheme,
+	passwordHashScheme,
+	emailOutboxScheme,
+	auditLogScheme,
+	profileScheme,
+	tokenRevocationScheme,
+	invitesScheme,
+	spentChallengesScheme,
+	avatarsScheme,
+}
+
 type App struct {
-	Config   *config.Config
-	Database *sql.DB
-	Router   *gin.Engine
-	Services []services.Service
+	Config         *config.Config
+	Database       *sql.DB
+	Router         *gin.Engine
+	Services       []services.Service
+	PasswordPolicy *policy.Policy
+	PasswordHasher *password.Hasher
+	Mailer         mailer.Mailer
+	MailQueue      *outbox.Queue
+	Keys           *userauth.KeyRing
 }
 
 // New creates the application instance
@@ -45,21 +266,50 @@ func New() *App {
 		log.Fatal(err)
 	}
 	migrateTable(db)
+	removeLegacyAvatars(cfg)
+	passwordPolicy, err := policy.Load(&cfg.PasswordPolicy)
+	if err != nil {
+		log.Fatal("password policy: ", err)
+	}
+	keys, err := userauth.LoadKeyRing(cfg.SecretKey, &cfg.JWTKeys)
+	if err != nil {
+		log.Fatal("jwt keys: ", err)
+	}
+	transport, err := mailer.New(&cfg.Mail, &cfg.SMTP)
+	if err != nil {
+		log.Fatal("mailer: ", err)
+	}
+	mailQueue := outbox.NewQueue(outbox.NewManager(db), transport, cfg.Mail.Workers, cfg.Mail.MaxAttempts)
 
 	return &App{
-		Config:   cfg,
-		Database: db,
-		Router:   gin.New(),
-		Services: make([]services.Service, 0),
+		Config:         cfg,
+		Database:       db,
+		Router:         gin.New(),
+		Services:       make([]services.Service, 0),
+		PasswordPolicy: passwordPolicy,
+		PasswordHasher: password.Load(&cfg.PasswordHash),
+		Mailer:         mailQueue,
+		MailQueue:      mailQueue,
+		Keys:           keys,
+	}
+}
+
+// removeLegacyAvatars deletes avatar files stored on disk before avatars moved to the database,
+// nothing references them anymore and files of purged accounts would stay otherwise
+func removeLegacyAvatars(cfg *config.Config) {
+	if err := os.RemoveAll(filepath.Join(cfg.TempDir, "avatars")); err != nil {
+		log.Println("legacy avatars removal error: " + err.Error())
 	}
 }
 
-// Close does cleaning operations on the application
+// Close does cleaning operations on the application, services and the mail queue still use the database
+// so it's closed last
 func (app *App) Close() {
-	_ = app.Database.Close()
 	for _, s := range app.Services {
 		s.Close()
 	}
+	app.MailQueue.Close()
+	_ = app.Database.Close()
 }
 
 func (app *App) initializeServices() {
@@ -69,30 +319,77 @@ func (app *App) initializeServices() {
 	app.Router.HandleMethodNotAllowed = true
 	app.Router.NoMethod(handlers.NoMethod)
 
+	app.Router.GET("/.well-known/jwks.json", userauth.JWKSHandler(app.Keys))
+
 	apiRouter := app.Router.Group("/api")
 
 	authRouter := apiRouter.Group("/auth")
-	authService := auth.NewService(app.Config, app.Database)
+	authService := auth.NewService(app.Config, app.Database, app.PasswordPolicy, app.PasswordHasher, app.Keys, app.Mailer)
 	authService.Register(authRouter)
 	app.Services = append(app.Services, authService)
 
+	userManager := user.NewManager(app.Database, app.PasswordPolicy, app.PasswordHasher)
+	auditManager := audit.NewManager(app.Database)
+	authMiddleware := userauth.Middleware(app.Keys,
+		userauth.WithValidator(userManager.ValidateToken),
+		userauth.WithFailureHook(auditManager.RecordTokenRejection),
+		userauth.WithImpersonationHook(auditManager.RecordImpersonatedRequest))
+	// personal API keys are accepted by services scripts work with, but never by the admin service
+	apiKeyMiddleware := userauth.Middleware(app.Keys,
+		userauth.WithValidator(userManager.ValidateToken),
+		userauth.WithAPIKeys(userManager.GetAPIKeyClaims),
+		userauth.WithFailureHook(auditManager.RecordTokenRejection),
+		userauth.WithImpersonationHook(auditManager.RecordImpersonatedRequest))
+	if err := userManager.PromoteAdmins(app.Config.AdminEmails); err != nil {
+		log.Println("admin promotion error: " + err.Error())
+	}
+	if !app.Config.ProofOfWork.Enabled {
+		log.Println("proof of work is disabled, signups and restore emails aren't protected from bots, " +
+			"set POW_ENABLED=true once clients solve challenges")
+	}
+
 	egeRouter := apiRouter.Group("/ege")
-	egeRouter.Use(userauth.Middleware(app.Config.SecretKey))
+	egeRouter.Use(apiKeyMiddleware, userauth.RequireScope(userauth.ScopeEge))
+	if app.Config.RequireVerifiedEmail {
+		egeRouter.Use(userauth.RequireVerifiedEmail())
+	}
 	egeService := ege.NewService(app.Config)
 	egeService.Register(egeRouter)
 	app.Services = append(app.Services, egeService)
 
 	chatRouter := apiRouter.Group("/chat")
-	chatRouter.Use(userauth.Middleware(app.Config.SecretKey))
-	chatService := chat.NewService()
+	chatRouter.Use(apiKeyMiddleware, userauth.RequireScope(userauth.ScopeChat))
+	if app.Config.RequireVerifiedEmail {
+		chatRouter.Use(userauth.RequireVerifiedEmail())
+	}
+	chatService := chat.NewService(app.Database)
 	chatService.Register(chatRouter)
 	app.Services = append(app.Services, chatService)
+
+	usersRouter := apiRouter.Group("/users")
+	usersService := users.NewService(app.Config, app.Database, authMiddleware)
+	usersService.Register(usersRouter)
+	app.Services = append(app.Services, usersService)
+
+	invitesRouter := apiRouter.Group("/invites")
+	invitesRouter.Use(authMiddleware, userauth.RequireRole(userauth.RoleTeacher, userauth.RoleAdmin))
+	invitesService := invites.NewService(app.Database)
+	invitesService.Register(invitesRouter)
+	app.Services = append(app.Services, invitesService)
+
+	adminRouter := apiRouter.Group("/admin")
+	adminRouter.Use(authMiddleware, userauth.RequireRole(userauth.RoleAdmin))
+	adminService := admin.NewService(app.Config, app.Database, app.PasswordPolicy, app.PasswordHasher, app.Keys, app.Mailer)
+	adminService.Register(adminRouter)
+	app.Services = append(app.Services, adminService)
 }
 
 func migrateTable(db *sql.DB) {
-	_, err := db.Exec(migrationScheme)
-	if err != nil {
-		log.Fatal("table migration: ", err)
+	for _, scheme := range migrationSchemes {
+		_, err := db.Exec(scheme)
+		if err != nil {
+			log.Fatal("table migration: ", err)
+		}
 	}
 }
 
@@ -0,0 +1,110 @@
+// Package emails renders localized plain text and HTML bodies of emails sent to users
+package emails
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Names of the templates
+const (
+	Verification       = "verification"
+	RestoreCode        = "restore_code"
+	Unlock             = "unlock"
+	MagicLink          = "magic_link"
+	EmailChangeConfirm = "email_change_confirm"
+	EmailChangeNotice  = "email_change_notice"
+	EmailChanged       = "email_changed"
+	PasswordChanged    = "password_changed"
+)
+
+// ErrUnknownTemplate is returned for template names missing from the default locale
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// DefaultLocale is used when none of the locales a client accepts is supported
+const DefaultLocale = "en"
+
+// Locales lists supported locales, each of them has every template
+var Locales = []string{"en", "ru"}
+
+var names = []string{
+	Verification, RestoreCode, Unlock, MagicLink, EmailChangeConfirm, EmailChangeNotice, EmailChanged, PasswordChanged,
+}
+
+//go:embed templates
+var templatesFS embed.FS
+
+type localizedTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// templates maps a locale and a template name to parsed templates, they are embedded so parsing can't fail at runtime
+var templates = make(map[string]localizedTemplate)
+
+func init() {
+	for _, locale := range Locales {
+		for _, name := range names {
+			path := "templates/" + locale + "/" + name
+			templates[locale+"/"+name] = localizedTemplate{
+				text: texttemplate.Must(texttemplate.ParseFS(templatesFS, path+".txt")),
+				html: htmltemplate.Must(htmltemplate.ParseFS(templatesFS, "templates/layout.html", path+".html")),
+			}
+		}
+	}
+}
+
+// Data is passed to templates, templates show the link if it's set and the code otherwise
+type Data struct {
+	Email    string
+	NewEmail string
+	Code     string
+	Link     string
+	// Locale and Subject are filled in by Render
+	Locale  string
+	Subject string
+}
+
+// Render returns the subject, the plain text and the HTML body of a template in a given locale
+func Render(locale, name string, data Data) (string, string, string, error) {
+	tmpl, ok := templates[locale+"/"+name]
+	if !ok {
+		locale = DefaultLocale
+		tmpl = templates[locale+"/"+name]
+	}
+	if tmpl.text == nil {
+		return "", "", "", ErrUnknownTemplate
+	}
+	data.Locale = locale
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", "", err
+	}
+	data.Subject = subject.String()
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return "", "", "", err
+	}
+	if err := tmpl.html.ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return "", "", "", err
+	}
+	return data.Subject, text.String(), html.String(), nil
+}
+
+// MatchLocale picks a supported locale from an Accept-Language header value, the order of preference is kept
+// while quality values are ignored
+func MatchLocale(acceptLanguage string) string {
+	for _, item := range strings.Split(acceptLanguage, ",") {
+		tag := strings.TrimSpace(strings.SplitN(item, ";", 2)[0])
+		language := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		for _, locale := range Locales {
+			if language == locale {
+				return locale
+			}
+		}
+	}
+	return DefaultLocale
+}
@@ -0,0 +1,53 @@
+package emails
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	data := Data{
+		Email:    "loh@mail.ru",
+		NewEmail: "new@mail.ru",
+		Code:     "CODE1234",
+		Link:     "https://fetchapp.example/action?token=<token>",
+	}
+	for _, locale := range Locales {
+		for _, name := range names {
+			t.Run(locale+"/"+name,
+				func(t *testing.T) {
+					subject, text, html, err := Render(locale, name, data)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if subject == "" || text == "" || html == "" {
+						t.Errorf("expected every part to be rendered, got subject: %q, text: %q, html: %q", subject, text, html)
+					}
+					if strings.Contains(html, "<token>") {
+						t.Error("expected data to be escaped in HTML")
+					}
+				})
+		}
+	}
+	t.Run("Unsupported locale falls back to the default one",
+		func(t *testing.T) {
+			subject, _, _, err := Render("de", Verification, data)
+			expected, _, _, _ := Render(DefaultLocale, Verification, data)
+			if err != nil || subject != expected {
+				t.Errorf("expected subject: %q, got: %q, %v", expected, subject, err)
+			}
+		})
+}
+
+func TestMatchLocale(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                        DefaultLocale,
+		"ru-RU,ru;q=0.9,en;q=0.8": "ru",
+		"de-DE, en-US;q=0.7":      "en",
+		"fr":                      DefaultLocale,
+	} {
+		if locale := MatchLocale(header); locale != expected {
+			t.Errorf("expected locale of %q: %v, got: %v", header, expected, locale)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInternal is returned when the database can't be accessed
+var ErrInternal = errors.New("internal error")
+
+// Security events
+const (
+	EventSignup                = "signup"
+	EventLoginSucceeded        = "login_succeeded"
+	EventLoginFailed           = "login_failed"
+	EventPasswordChanged       = "password_changed"
+	EventRestoreRequested      = "restore_requested"
+	EventRestoreUsed           = "restore_used"
+	EventTokenRejected         = "token_rejected"
+	EventRoleChanged           = "role_changed"
+	EventAccountDisabled       = "account_disabled"
+	EventAccountEnabled        = "account_enabled"
+	EventForcedLogout          = "forced_logout"
+	EventImpersonated          = "impersonated"
+	EventImpersonatedRequest   = "impersonated_request"
+	EventPasswordResetRequired = "password_reset_required"
+)
+
+// Entry is a recorded security event
+type Entry struct {
+	ID    int64  `json:"id"`
+	Event string `json:"event"`
+	// UserID and Email identify the account the event is about, the id is empty if there's no such account
+	UserID *int   `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+	// Actor is an email of whoever caused the event, it differs from Email when an administrator acts on an account
+	Actor     string    `json:"actor,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Filter narrows down queried entries, zero fields match everything
+type Filter struct {
+	Event  string
+	UserID int
+	Email  string
+	Actor  string
+	IP     string
+	Since  time.Time
+	Until  time.Time
+}
+
+// where returns a condition matching the filter along with its arguments
+func (filter *Filter) where() (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	args := make([]interface{}, 0)
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.Replace(condition, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if filter.Event != "" {
+		add("event = ?", filter.Event)
+	}
+	if filter.UserID != 0 {
+		add("user_id = ?", filter.UserID)
+	}
+	if filter.Email != "" {
+		add("email = ?", filter.Email)
+	}
+	if filter.Actor != "" {
+		add("actor = ?", filter.Actor)
+	}
+	if filter.IP != "" {
+		add("ip = ?", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < ?", filter.Until)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+const entryColumns = "ID, event, user_id, email, actor, ip, user_agent, details, created_at"
+
+// Erase pseudonymises entries about or caused by an user whose account is being deleted in a transaction.
+// Entries are kept with the user id so that a history of events stays intact, but the email and client
+// details are cleared. The append-only trigger lets the update through only within the transaction
+func Erase(tx *sql.Tx, userID int, email string) error {
+	if _, err := tx.Exec("SET LOCAL audit_log.erasure = 'on'"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("UPDATE AuditLog SET email = CASE WHEN user_id = $1 OR email = $2 THEN '' ELSE email END, "+
+		"actor = CASE WHEN actor = $2 THEN '' ELSE actor END, "+
+		"ip = CASE WHEN user_id = $1 OR email = $2 THEN '' ELSE ip END, "+
+		"user_agent = CASE WHEN user_id = $1 OR email = $2 THEN '' ELSE user_agent END "+
+		"WHERE user_id = $1 OR email = $2 OR actor = $2", userID, email)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("SET LOCAL audit_log.erasure = 'off'")
+	return err
+}
+
+// Manager appends security events to the audit log and queries them, the table rejects updates and deletions
+type Manager struct {
+	Database *sql.DB
+}
+
+// NewManager returns an audit log manager
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{
+		Database: db,
+	}
+}
+
+// Record appends an entry, the account id is looked up by email when it isn't set and vice versa.
+// Failures are only logged so that auditing never breaks a request
+func (manager *Manager) Record(entry *Entry) {
+	_, err := manager.Database.Exec("INSERT INTO AuditLog (event, user_id, email, actor, ip, user_agent, details) "+
+		"VALUES ($1, COALESCE($2, (SELECT ID FROM Users WHERE email = $3)), "+
+		"COALESCE(NULLIF($3, ''), (SELECT email FROM Users WHERE ID = $2), ''), $4, $5, $6, $7)",
+		entry.Event, entry.UserID, entry.Email, entry.Actor, entry.IP, entry.UserAgent, entry.Details)
+	if err != nil {
+		log.Println("audit.Record error: " + err.Error())
+	}
+}
+
+// Query returns a page of entries matching a filter newest first along with the total number of such entries
+func (manager *Manager) Query(filter *Filter, offset, limit int) ([]Entry, int, error) {
+	where, args := filter.where()
+	var total int
+	if err := manager.Database.QueryRow("SELECT COUNT(*) FROM AuditLog WHERE "+where, args...).Scan(&total); err != nil {
+		log.Println("audit.Query error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	query := "SELECT " + entryColumns + " FROM AuditLog WHERE " + where + " ORDER BY ID DESC OFFSET $" +
+		strconv.Itoa(len(args)+1) + " LIMIT $" + strconv.Itoa(len(args)+2)
+	entries := make([]Entry, 0)
+	err := manager.scan(query, append(args, offset, limit), func(entry *Entry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		log.Println("audit.Query error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	return entries, total, nil
+}
+
+// Export calls fn for every entry matching a filter oldest first, it stops on the first error fn returns
+func (manager *Manager) Export(filter *Filter, fn func(entry *Entry) error) error {
+	where, args := filter.where()
+	return manager.scan("SELECT "+entryColumns+" FROM AuditLog WHERE "+where+" ORDER BY ID", args, fn)
+}
+
+// ForUser returns the most recent entries about an user
+func (manager *Manager) ForUser(userID, limit int) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	err := manager.scan("SELECT "+entryColumns+" FROM AuditLog WHERE user_id = $1 "+
+		"ORDER BY ID DESC LIMIT $2", []interface{}{userID, limit}, func(entry *Entry) error {
+		entries = append(entries, *entry)
+		return nil
+	})
+	if err != nil {
+		log.Println("audit.ForUser error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return entries, nil
+}
+
+// scan runs a query selecting entryColumns and calls fn for every row
+func (manager *Manager) scan(query string, args []interface{}, fn func(entry *Entry) error) error {
+	rows, err := manager.Database.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry Entry
+		err = rows.Scan(&entry.ID, &entry.Event, &entry.UserID, &entry.Email, &entry.Actor, &entry.IP,
+			&entry.UserAgent, &entry.Details, &entry.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if err = fn(&entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
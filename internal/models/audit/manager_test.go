@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFilterWhere(t *testing.T) {
+	since := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	filter := Filter{
+		Event:  EventLoginFailed,
+		UserID: 7,
+		IP:     "127.0.0.1",
+		Since:  since,
+	}
+	where, args := filter.where()
+	expected := "TRUE AND event = $1 AND user_id = $2 AND ip = $3 AND created_at >= $4"
+	if where != expected {
+		t.Errorf("got: %s, expected: %s", where, expected)
+	}
+	if !reflect.DeepEqual(args, []interface{}{EventLoginFailed, 7, "127.0.0.1", since}) {
+		t.Errorf("unexpected arguments: %v", args)
+	}
+	if where, args = (&Filter{}).where(); where != "TRUE" || len(args) != 0 {
+		t.Errorf("empty filter, got: %s %v", where, args)
+	}
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/gin-gonic/gin"
+)
+
+// NewEntry returns an entry about an account caused by a client of a request, the account owner is the actor
+func NewEntry(c *gin.Context, event, email string) *Entry {
+	return &Entry{
+		Event:     event,
+		Email:     email,
+		Actor:     email,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
+
+// RecordTokenRejection is a userauth.FailureHook recording requests rejected by the auth middleware
+func (manager *Manager) RecordTokenRejection(c *gin.Context, claims *userauth.Claims, reason string) {
+	entry := NewEntry(c, EventTokenRejected, "")
+	if claims != nil {
+		entry.Email = claims.Email
+		entry.Actor = claims.Email
+		if !claims.IsLegacy() {
+			entry.UserID = &claims.UserID
+		}
+	}
+	entry.Details = c.Request.Method + " " + c.FullPath() + ": " + reason
+	manager.Record(entry)
+}
+
+// RecordImpersonatedRequest is a userauth.ImpersonationHook recording requests which may change anything,
+// the administrator who issued the token is the actor
+func (manager *Manager) RecordImpersonatedRequest(c *gin.Context, claims *userauth.Claims) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return
+	}
+	entry := NewEntry(c, EventImpersonatedRequest, claims.Email)
+	entry.UserID = &claims.UserID
+	row := manager.Database.QueryRow("SELECT email FROM Users WHERE ID = $1", claims.ImpersonatorID)
+	if err := row.Scan(&entry.Actor); err != nil {
+		log.Println("audit.RecordImpersonatedRequest error: " + err.Error())
+	}
+	entry.Details = c.Request.Method + " " + c.FullPath() + ": " + strconv.Itoa(c.Writer.Status()) + ", token " + claims.Id
+	manager.Record(entry)
+}
@@ -0,0 +1,108 @@
+package challenge
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+var (
+	// ErrInternal is returned when the database can't be accessed
+	ErrInternal = errors.New("internal error")
+	// ErrSpent is returned when a challenge solution has already been used
+	ErrSpent = errors.New("the challenge has already been used")
+)
+
+// Policy describes how difficulty follows the load
+type Policy struct {
+	// BaseDifficulty is a number of leading zero bits required when the load is normal
+	BaseDifficulty int
+	// MaxDifficulty caps the difficulty
+	MaxDifficulty int
+	// Threshold is a number of protected requests per window considered normal for all clients
+	Threshold int
+	// IPThreshold is a number of protected requests per window considered normal for a single address
+	IPThreshold int
+	// Window is how far back requests are counted
+	Window time.Duration
+}
+
+// DefaultPolicy counts requests over the last minute, difficulties are set from the config
+var DefaultPolicy = Policy{
+	Threshold:   60,
+	IPThreshold: 5,
+	Window:      time.Minute,
+}
+
+// Lifespan is how long an issued challenge can be solved
+const Lifespan = time.Minute * 10
+
+// Manager keeps spent challenges in the database so that a solution works once across instances.
+// Spent challenges also measure the recent load difficulty adapts to
+type Manager struct {
+	Database *sql.DB
+}
+
+// NewManager returns a challenge manager
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{
+		Database: db,
+	}
+}
+
+// Spend marks a challenge solved from an address as used, it fails with ErrSpent if it has already been
+func (manager *Manager) Spend(nonce, ip string, expiresAt time.Time) error {
+	result, err := manager.Database.Exec("INSERT INTO SpentChallenges (nonce, ip, expires_at) VALUES ($1, $2, $3) "+
+		"ON CONFLICT (nonce) DO NOTHING", nonce, ip, expiresAt)
+	if err != nil {
+		log.Println("challenge.Spend error: " + err.Error())
+		return ErrInternal
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return ErrSpent
+	}
+	return nil
+}
+
+// Difficulty returns a difficulty of a challenge issued to an address under the current load
+func (manager *Manager) Difficulty(ip string, policy Policy) (int, error) {
+	var total, fromIP int
+	row := manager.Database.QueryRow("SELECT COUNT(*), COUNT(*) FILTER (WHERE ip = $1) FROM SpentChallenges "+
+		"WHERE spent_at > NOW() - $2 * INTERVAL '1 second'", ip, int(policy.Window.Seconds()))
+	if err := row.Scan(&total, &fromIP); err != nil {
+		log.Println("challenge.Difficulty error: " + err.Error())
+		return 0, ErrInternal
+	}
+	return Difficulty(total, fromIP, policy), nil
+}
+
+// Purge deletes spent challenges which have expired and can't be replayed anyway once they stop counting as load
+func (manager *Manager) Purge() (int64, error) {
+	result, err := manager.Database.Exec("DELETE FROM SpentChallenges WHERE expires_at < NOW() "+
+		"AND spent_at < NOW() - $1 * INTERVAL '1 second'", int(DefaultPolicy.Window.Seconds()))
+	if err != nil {
+		log.Println("challenge.Purge error: " + err.Error())
+		return 0, ErrInternal
+	}
+	return result.RowsAffected()
+}
+
+// Difficulty returns a difficulty for a given number of recent requests in total and from an address.
+// Every doubling of the load over a threshold adds a bit, which doubles the expected work
+func Difficulty(total, fromIP int, policy Policy) int {
+	difficulty := policy.BaseDifficulty + extraBits(total, policy.Threshold) + extraBits(fromIP, policy.IPThreshold)
+	if difficulty > policy.MaxDifficulty {
+		return policy.MaxDifficulty
+	}
+	return difficulty
+}
+
+// extraBits returns how many times the load has doubled over the threshold
+func extraBits(load, threshold int) int {
+	bits := 0
+	for load /= threshold; load > 0; load >>= 1 {
+		bits++
+	}
+	return bits
+}
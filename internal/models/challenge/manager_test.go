@@ -0,0 +1,30 @@
+package challenge
+
+import "testing"
+
+func TestDifficulty(t *testing.T) {
+	policy := Policy{
+		BaseDifficulty: 16,
+		MaxDifficulty:  20,
+		Threshold:      10,
+		IPThreshold:    2,
+	}
+	for _, tc := range []struct {
+		total, fromIP int
+		expected      int
+	}{
+		{0, 0, 16},
+		{9, 1, 16},
+		{10, 1, 17},
+		{19, 1, 17},
+		{20, 1, 18},
+		{40, 1, 19},
+		{20, 2, 19},
+		{20, 4, 20},
+		{1000, 100, 20},
+	} {
+		if got := Difficulty(tc.total, tc.fromIP, policy); got != tc.expected {
+			t.Errorf("total: %d, from ip: %d: got %d, expected %d", tc.total, tc.fromIP, got, tc.expected)
+		}
+	}
+}
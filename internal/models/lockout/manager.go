@@ -0,0 +1,138 @@
+package lockout
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ErrInternal is returned when the database can't be accessed
+var ErrInternal = errors.New("internal error")
+
+// Policy describes how failed attempts are punished
+type Policy struct {
+	// Threshold is a number of failed attempts after which a key gets locked
+	Threshold int
+	// BaseDelay is a duration of the first lock, every next failure doubles it
+	BaseDelay time.Duration
+	// MaxDelay caps a lock duration
+	MaxDelay time.Duration
+}
+
+var (
+	// AccountPolicy is applied to failures related to a single account
+	AccountPolicy = Policy{
+		Threshold: 5,
+		BaseDelay: time.Second * 30,
+		MaxDelay:  time.Hour,
+	}
+	// IPPolicy is applied to failures coming from a single address, it's looser since many users can share one
+	IPPolicy = Policy{
+		Threshold: 20,
+		BaseDelay: time.Second * 30,
+		MaxDelay:  time.Hour,
+	}
+)
+
+// failuresLifespan is how long failures are remembered after the last one
+const failuresLifespan = time.Hour * 24
+
+// Manager tracks failed authentication attempts in the database so that they are shared between instances
+type Manager struct {
+	Database *sql.DB
+}
+
+// NewManager returns a lockout manager
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{
+		Database: db,
+	}
+}
+
+// AccountKey returns a key tracking failed logins of an account
+func AccountKey(email string) string {
+	return "account:" + email
+}
+
+// RestoreKey returns a key tracking failed restore code checks of an account
+func RestoreKey(email string) string {
+	return "restore:" + email
+}
+
+// IPKey returns a key tracking failures from an address
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns how long the longest lock of given keys lasts, zero if none of them is locked
+func (manager *Manager) Check(keys ...string) (time.Duration, error) {
+	var seconds float64
+	row := manager.Database.QueryRow("SELECT COALESCE(MAX(EXTRACT(EPOCH FROM locked_until - NOW())), 0) "+
+		"FROM AuthFailures WHERE key = ANY($1) AND locked_until > NOW()", pq.Array(keys))
+	if err := row.Scan(&seconds); err != nil {
+		log.Println("lockout.Check error: " + err.Error())
+		return 0, ErrInternal
+	}
+	return time.Duration(math.Ceil(seconds)) * time.Second, nil
+}
+
+// Fail registers a failed attempt for a key and returns a number of recent failures with a lock duration.
+// Every failure starting from the policy threshold locks the key twice as long as the previous one
+func (manager *Manager) Fail(key string, policy Policy) (int, time.Duration, error) {
+	_, err := manager.Database.Exec("DELETE FROM AuthFailures WHERE updated_at < NOW() - $1 * INTERVAL '1 second'",
+		int(failuresLifespan.Seconds()))
+	if err != nil {
+		log.Println("lockout.Fail error: " + err.Error())
+		return 0, 0, ErrInternal
+	}
+	var failures int
+	row := manager.Database.QueryRow("INSERT INTO AuthFailures (key, failures, updated_at) VALUES ($1, 1, NOW()) "+
+		"ON CONFLICT (key) DO UPDATE SET failures = CASE "+
+		"WHEN AuthFailures.updated_at < NOW() - $2 * INTERVAL '1 second' THEN 1 "+
+		"ELSE AuthFailures.failures + 1 END, updated_at = NOW() RETURNING failures",
+		key, int(failuresLifespan.Seconds()))
+	if err := row.Scan(&failures); err != nil {
+		log.Println("lockout.Fail error: " + err.Error())
+		return 0, 0, ErrInternal
+	}
+	delay := Delay(failures, policy)
+	if delay == 0 {
+		return failures, 0, nil
+	}
+	_, err = manager.Database.Exec("UPDATE AuthFailures SET locked_until = NOW() + $2 * INTERVAL '1 second' WHERE key = $1",
+		key, int(delay.Seconds()))
+	if err != nil {
+		log.Println("lockout.Fail error: " + err.Error())
+		return 0, 0, ErrInternal
+	}
+	return failures, delay, nil
+}
+
+// Reset forgets failures of a key and unlocks it
+func (manager *Manager) Reset(key string) error {
+	_, err := manager.Database.Exec("DELETE FROM AuthFailures WHERE key = $1", key)
+	if err != nil {
+		log.Println("lockout.Reset error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// Delay returns a lock duration after a given number of failures
+func Delay(failures int, policy Policy) time.Duration {
+	if failures < policy.Threshold {
+		return 0
+	}
+	delay := policy.BaseDelay
+	for i := policy.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= policy.MaxDelay {
+			return policy.MaxDelay
+		}
+	}
+	return delay
+}
@@ -0,0 +1,28 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	policy := Policy{
+		Threshold: 3,
+		BaseDelay: time.Second * 10,
+		MaxDelay:  time.Minute,
+	}
+	cases := map[int]time.Duration{
+		1: 0,
+		2: 0,
+		3: time.Second * 10,
+		4: time.Second * 20,
+		5: time.Second * 40,
+		6: time.Minute,
+		9: time.Minute,
+	}
+	for failures, expected := range cases {
+		if delay := Delay(failures, policy); delay != expected {
+			t.Errorf("failures: %d, got: %v, expected: %v", failures, delay, expected)
+		}
+	}
+}
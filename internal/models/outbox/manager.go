@@ -0,0 +1,284 @@
+package outbox
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/mail"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
+	"github.com/lib/pq"
+)
+
+var (
+	// ErrInternal is returned when the database can't be accessed
+	ErrInternal = errors.New("internal error")
+	// ErrNoMessage is returned when there is no dead-lettered message with a given id
+	ErrNoMessage = errors.New("no dead message with such id")
+	// ErrInvalidStatus is returned when messages are filtered by an unknown status
+	ErrInvalidStatus = errors.New("invalid status provided")
+	// ErrErased is returned when a dead-lettered message carried codes or links, so its body is gone
+	ErrErased = errors.New("the message body has been erased, the user has to request it again")
+)
+
+// Message statuses
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+const (
+	// baseBackoff is a delay before the second attempt, every next failure doubles it
+	baseBackoff = time.Minute
+	// maxBackoff caps a delay between attempts
+	maxBackoff = time.Hour * 6
+	// leaseDuration is how long a claimed message is hidden from other workers, a message of a crashed worker
+	// becomes available again after it
+	leaseDuration = time.Minute * 5
+	// sentLifespan is how long sent messages are kept, their bodies are erased right after sending
+	sentLifespan = time.Hour * 24
+	// deadLifespan is how long dead messages are kept for administrators to resend
+	deadLifespan = time.Hour * 24 * 3
+	// maxLifespan is how long any message is kept regardless of its status
+	maxLifespan = time.Hour * 24 * 7
+)
+
+// Message is a queued email as seen by administrators, bodies are left out since they contain tokens
+type Message struct {
+	ID            int        `json:"id"`
+	To            []string   `json:"to"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	SentAt        *time.Time `json:"sent_at"`
+	// Sensitive messages carry codes or links, they can't be resent once dead
+	Sensitive bool `json:"sensitive"`
+}
+
+// entry is a claimed message ready to be delivered
+type entry struct {
+	id       int
+	attempts int
+	message  *mailer.Message
+}
+
+// Manager stores outgoing emails in the database so they survive restarts and failures of the mail server
+type Manager struct {
+	Database *sql.DB
+}
+
+// NewManager returns an outbox manager
+func NewManager(db *sql.DB) *Manager {
+	return &Manager{
+		Database: db,
+	}
+}
+
+// Backoff returns a delay before the next delivery attempt after a given number of failed ones
+func Backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// IsValidStatus checks whether a status exists
+func IsValidStatus(status string) bool {
+	return status == StatusPending || status == StatusSent || status == StatusDead
+}
+
+// Enqueue stores a message to be delivered by workers
+func (manager *Manager) Enqueue(message *mailer.Message) error {
+	to := make([]string, len(message.To))
+	for i := range message.To {
+		to[i] = message.To[i].String()
+	}
+	_, err := manager.Database.Exec("INSERT INTO EmailOutbox (sender, recipients, subject, text_body, html_body, sensitive) "+
+		"VALUES ($1, $2, $3, $4, $5, $6)", message.From.String(), pq.Array(to), message.Subject, message.Text, message.HTML,
+		message.Sensitive)
+	if err != nil {
+		log.Println("outbox.Enqueue error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// claim leases up to limit due messages and counts an attempt for each of them.
+// Locked rows are skipped so several instances can share the outbox
+func (manager *Manager) claim(limit int) ([]entry, error) {
+	rows, err := manager.Database.Query("UPDATE EmailOutbox SET attempts = attempts + 1, "+
+		"locked_until = NOW() + $2 * INTERVAL '1 second' WHERE ID IN ("+
+		"SELECT ID FROM EmailOutbox WHERE status = 'pending' AND next_attempt_at <= NOW() "+
+		"AND (locked_until IS NULL OR locked_until <= NOW()) "+
+		"ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED) "+
+		"RETURNING ID, attempts, sender, recipients, subject, text_body, html_body",
+		limit, leaseDuration.Seconds())
+	if err != nil {
+		log.Println("outbox.claim error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	entries := make([]entry, 0, limit)
+	for rows.Next() {
+		var (
+			claimed entry
+			from    string
+			to      []string
+		)
+		claimed.message = &mailer.Message{}
+		err = rows.Scan(&claimed.id, &claimed.attempts, &from, pq.Array(&to), &claimed.message.Subject,
+			&claimed.message.Text, &claimed.message.HTML)
+		if err != nil {
+			log.Println("outbox.claim error: " + err.Error())
+			return nil, ErrInternal
+		}
+		claimed.message.From = parseAddress(from)
+		for _, address := range to {
+			claimed.message.To = append(claimed.message.To, parseAddress(address))
+		}
+		entries = append(entries, claimed)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("outbox.claim error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return entries, nil
+}
+
+// parseAddress parses an address stored by Enqueue falling back to a bare address
+func parseAddress(address string) mail.Address {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return mail.Address{Address: address}
+	}
+	return *parsed
+}
+
+// markSent marks a delivered message and erases its body, it isn't needed anymore
+func (manager *Manager) markSent(id int) {
+	_, err := manager.Database.Exec("UPDATE EmailOutbox SET status = 'sent', sent_at = NOW(), locked_until = NULL, "+
+		"last_error = '', text_body = '', html_body = '' WHERE ID = $1", id)
+	if err != nil {
+		log.Println("outbox.markSent error: " + err.Error())
+	}
+}
+
+// markFailed schedules the next attempt of a message or dead-letters it when attempts are exhausted,
+// bodies of dead sensitive messages are erased
+func (manager *Manager) markFailed(failed entry, maxAttempts int, sendErr error) {
+	var err error
+	if failed.attempts >= maxAttempts {
+		_, err = manager.Database.Exec("UPDATE EmailOutbox SET status = 'dead', locked_until = NULL, last_error = $2, "+
+			"text_body = CASE WHEN sensitive THEN '' ELSE text_body END, "+
+			"html_body = CASE WHEN sensitive THEN '' ELSE html_body END WHERE ID = $1", failed.id, sendErr.Error())
+	} else {
+		_, err = manager.Database.Exec("UPDATE EmailOutbox SET locked_until = NULL, last_error = $2, "+
+			"next_attempt_at = NOW() + $3 * INTERVAL '1 second' WHERE ID = $1",
+			failed.id, sendErr.Error(), Backoff(failed.attempts).Seconds())
+	}
+	if err != nil {
+		log.Println("outbox.markFailed error: " + err.Error())
+	}
+}
+
+// release returns claimed but not attempted messages to the queue
+func (manager *Manager) release(entries []entry) {
+	ids := make([]int64, len(entries))
+	for i := range entries {
+		ids[i] = int64(entries[i].id)
+	}
+	_, err := manager.Database.Exec("UPDATE EmailOutbox SET attempts = attempts - 1, locked_until = NULL "+
+		"WHERE ID = ANY($1)", pq.Array(ids))
+	if err != nil {
+		log.Println("outbox.release error: " + err.Error())
+	}
+}
+
+// purge deletes messages sent or given up on long enough ago along with messages which are too old
+func (manager *Manager) purge() {
+	_, err := manager.Database.Exec("DELETE FROM EmailOutbox WHERE "+
+		"(status = 'sent' AND sent_at < NOW() - $1 * INTERVAL '1 second') OR "+
+		"(status = 'dead' AND next_attempt_at < NOW() - $2 * INTERVAL '1 second') OR "+
+		"created_at < NOW() - $3 * INTERVAL '1 second'",
+		sentLifespan.Seconds(), deadLifespan.Seconds(), maxLifespan.Seconds())
+	if err != nil {
+		log.Println("outbox.purge error: " + err.Error())
+	}
+}
+
+// DeleteFor deletes messages to an address within a transaction deleting the account it belongs to
+func DeleteFor(tx *sql.Tx, address string) error {
+	_, err := tx.Exec("DELETE FROM EmailOutbox WHERE recipients && $1",
+		pq.Array([]string{address, (&mail.Address{Address: address}).String()}))
+	return err
+}
+
+// List returns a page of messages with a given status, or of all messages if the status is empty,
+// newest first along with the total number of such messages
+func (manager *Manager) List(status string, offset, limit int) ([]Message, int, error) {
+	if status != "" && !IsValidStatus(status) {
+		return nil, 0, ErrInvalidStatus
+	}
+	var total int
+	row := manager.Database.QueryRow("SELECT COUNT(*) FROM EmailOutbox WHERE $1 = '' OR status = $1", status)
+	if err := row.Scan(&total); err != nil {
+		log.Println("outbox.List error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	rows, err := manager.Database.Query("SELECT ID, recipients, subject, status, attempts, last_error, "+
+		"next_attempt_at, created_at, sent_at, sensitive FROM EmailOutbox WHERE $1 = '' OR status = $1 "+
+		"ORDER BY ID DESC OFFSET $2 LIMIT $3", status, offset, limit)
+	if err != nil {
+		log.Println("outbox.List error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	defer rows.Close()
+	messages := make([]Message, 0)
+	for rows.Next() {
+		var message Message
+		err = rows.Scan(&message.ID, pq.Array(&message.To), &message.Subject, &message.Status, &message.Attempts,
+			&message.LastError, &message.NextAttemptAt, &message.CreatedAt, &message.SentAt, &message.Sensitive)
+		if err != nil {
+			log.Println("outbox.List error: " + err.Error())
+			return nil, 0, ErrInternal
+		}
+		messages = append(messages, message)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("outbox.List error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	return messages, total, nil
+}
+
+// Resend puts a dead-lettered message back to the queue with a fresh number of attempts,
+// sensitive messages have no body to send anymore
+func (manager *Manager) Resend(id int) error {
+	var sensitive bool
+	row := manager.Database.QueryRow("UPDATE EmailOutbox SET status = 'pending', attempts = 0, last_error = '', "+
+		"next_attempt_at = NOW() WHERE ID = $1 AND status = 'dead' AND NOT sensitive RETURNING sensitive", id)
+	err := row.Scan(&sensitive)
+	if err == sql.ErrNoRows {
+		row = manager.Database.QueryRow("SELECT sensitive FROM EmailOutbox WHERE ID = $1 AND status = 'dead'", id)
+		if err = row.Scan(&sensitive); err == nil {
+			return ErrErased
+		}
+		if err == sql.ErrNoRows {
+			return ErrNoMessage
+		}
+	}
+	if err != nil {
+		log.Println("outbox.Resend error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  time.Minute,
+		2:  time.Minute * 2,
+		3:  time.Minute * 4,
+		8:  time.Minute * 128,
+		9:  time.Minute * 256,
+		10: time.Hour * 6,
+		20: time.Hour * 6,
+	}
+	for attempts, expected := range cases {
+		if delay := Backoff(attempts); delay != expected {
+			t.Errorf("attempts: %d, got: %v, expected: %v", attempts, delay, expected)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package outbox
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
+)
+
+const (
+	// pollPeriod is how often the outbox is checked for due messages when nothing is enqueued
+	pollPeriod = time.Second * 10
+	// purgePeriod is how often old messages are purged
+	purgePeriod = time.Hour
+)
+
+// Queue is a mailer storing messages in the outbox, a pool of workers delivers them through a transport
+type Queue struct {
+	manager     *Manager
+	transport   mailer.Mailer
+	workers     int
+	maxAttempts int
+	jobs        chan entry
+	wake        chan struct{}
+	stop        chan struct{}
+	stopOnce    sync.Once
+	wg          sync.WaitGroup
+}
+
+// NewQueue returns a queue and starts its workers
+func NewQueue(manager *Manager, transport mailer.Mailer, workers, maxAttempts int) *Queue {
+	queue := &Queue{
+		manager:     manager,
+		transport:   transport,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		jobs:        make(chan entry),
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+	queue.wg.Add(workers + 1)
+	go queue.dispatch()
+	for i := 0; i < workers; i++ {
+		go queue.work()
+	}
+	return queue
+}
+
+// Send enqueues a message, it's delivered in background
+func (queue *Queue) Send(message *mailer.Message) error {
+	if err := queue.manager.Enqueue(message); err != nil {
+		return err
+	}
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Close stops claiming messages and waits for in-flight deliveries to finish
+func (queue *Queue) Close() {
+	queue.stopOnce.Do(func() {
+		close(queue.stop)
+	})
+	queue.wg.Wait()
+}
+
+// dispatch claims due messages and hands them to workers until the queue is closed
+func (queue *Queue) dispatch() {
+	defer queue.wg.Done()
+	defer close(queue.jobs)
+	ticker := time.NewTicker(pollPeriod)
+	defer ticker.Stop()
+	lastPurge := time.Time{}
+	for {
+		if time.Since(lastPurge) >= purgePeriod {
+			queue.manager.purge()
+			lastPurge = time.Now()
+		}
+		entries, _ := queue.manager.claim(queue.workers)
+		for i := range entries {
+			select {
+			case queue.jobs <- entries[i]:
+			case <-queue.stop:
+				queue.manager.release(entries[i:])
+				return
+			}
+		}
+		// a full batch means there may be more due messages
+		if len(entries) == queue.workers {
+			select {
+			case <-queue.stop:
+				return
+			default:
+				continue
+			}
+		}
+		select {
+		case <-queue.stop:
+			return
+		case <-queue.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// work delivers messages until the dispatcher is done
+func (queue *Queue) work() {
+	defer queue.wg.Done()
+	for job := range queue.jobs {
+		if err := queue.transport.Send(job.message); err != nil {
+			if job.attempts >= queue.maxAttempts {
+				log.Println("outbox: message " + strconv.Itoa(job.id) + " is dead after " +
+					strconv.Itoa(job.attempts) + " attempts: " + err.Error())
+			}
+			queue.manager.markFailed(job, queue.maxAttempts, err)
+			continue
+		}
+		queue.manager.markSent(job.id)
+	}
+}
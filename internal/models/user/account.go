@@ -0,0 +1,269 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/adjsky/fetchapp_server/internal/models/lockout"
+	"github.com/adjsky/fetchapp_server/internal/models/outbox"
+)
+
+// Export holds everything stored about an user grouped by sections, each section is serialized to JSON
+type Export map[string]interface{}
+
+// ScheduleDeletion checks the password of an user, or that an user without one has just signed in with the session,
+// and schedules a hard delete of the account after a grace period.
+// The user is logged out everywhere and returns the time the account will be deleted at
+func (manager *Manager) ScheduleDeletion(id int, sessionID, password string, gracePeriod time.Duration) (time.Time, error) {
+	if err := manager.reauthenticate(id, sessionID, password); err != nil {
+		return time.Time{}, err
+	}
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.ScheduleDeletion error: " + err.Error())
+		return time.Time{}, ErrInternal
+	}
+	defer tx.Rollback()
+	deletionAt := time.Now().Add(gracePeriod)
+	_, err = tx.Exec("UPDATE Users SET deletion_scheduled_at = $1 WHERE ID = $2", deletionAt, id)
+	if err == nil {
+		err = revokeUserSessions(tx, id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.ScheduleDeletion error: " + err.Error())
+		return time.Time{}, ErrInternal
+	}
+	return deletionAt, nil
+}
+
+// CancelDeletion keeps an account scheduled for deletion
+func (manager *Manager) CancelDeletion(id int) error {
+	result, err := manager.Database.Exec("UPDATE Users SET deletion_scheduled_at = NULL "+
+		"WHERE ID = $1 AND deletion_scheduled_at IS NOT NULL", id)
+	if err != nil {
+		log.Println("manager.CancelDeletion error: " + err.Error())
+		return ErrInternal
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoDeletion
+	}
+	return nil
+}
+
+// PurgeDeletedAccounts deletes accounts whose grace period is over and returns how many of them were deleted
+func (manager *Manager) PurgeDeletedAccounts() (int, error) {
+	rows, err := manager.Database.Query("SELECT ID, email FROM Users WHERE deletion_scheduled_at < NOW()")
+	if err != nil {
+		log.Println("manager.PurgeDeletedAccounts error: " + err.Error())
+		return 0, ErrInternal
+	}
+	type account struct {
+		id    int
+		email string
+	}
+	var accounts []account
+	for rows.Next() {
+		var acc account
+		if err = rows.Scan(&acc.id, &acc.email); err != nil {
+			rows.Close()
+			log.Println("manager.PurgeDeletedAccounts error: " + err.Error())
+			return 0, ErrInternal
+		}
+		accounts = append(accounts, acc)
+	}
+	rows.Close()
+	deleted := 0
+	for _, acc := range accounts {
+		if err = manager.Delete(acc.id, acc.email); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// Delete removes an user with everything referencing it. Tables with a foreign key to Users, avatars included,
+// are cleaned up by cascading, others are keyed by email and have to be cleaned up explicitly.
+// Audit log entries are kept without personal data
+func (manager *Manager) Delete(id int, email string) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.Delete error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM AuthFailures WHERE key = $1 OR key = $2",
+		lockout.AccountKey(email), lockout.RestoreKey(email))
+	if err == nil {
+		err = outbox.DeleteFor(tx, email)
+	}
+	if err == nil {
+		err = audit.Erase(tx, id, email)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM Users WHERE ID = $1", id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.Delete error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// Export collects personal data of an user, secrets like password hashes and codes are left out.
+// Every table storing data of an user has to be exported here
+func (manager *Manager) Export(userID int) (Export, error) {
+	var (
+		account struct {
+			Email                 string     `json:"email"`
+			EmailVerified         bool       `json:"email_verified"`
+			TOTPEnabled           bool       `json:"totp_enabled"`
+			Role                  string     `json:"role"`
+			DisabledAt            *time.Time `json:"disabled_at"`
+			PasswordResetRequired bool       `json:"password_reset_required"`
+			CreatedAt             time.Time  `json:"created_at"`
+			DeletionScheduledAt   *time.Time `json:"deletion_scheduled_at"`
+			RecoveryCodesLeft     int        `json:"recovery_codes_left"`
+		}
+	)
+	row := manager.Database.QueryRow("SELECT email, email_verified, totp_enabled, role, disabled_at, "+
+		"password_reset_required, created_at, deletion_scheduled_at, "+
+		"(SELECT COUNT(*) FROM RecoveryCodes r WHERE r.user_id = Users.ID AND r.used_at IS NULL) FROM Users WHERE ID = $1", userID)
+	err := row.Scan(&account.Email, &account.EmailVerified, &account.TOTPEnabled, &account.Role, &account.DisabledAt,
+		&account.PasswordResetRequired, &account.CreatedAt, &account.DeletionScheduledAt, &account.RecoveryCodesLeft)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoUser
+		}
+		log.Println("manager.Export error: " + err.Error())
+		return nil, ErrInternal
+	}
+	sessions, err := exportRows(manager.Database,
+		[]string{"id", "device", "user_agent", "ip", "created_at", "last_seen_at", "expires_at", "revoked_at"},
+		"SELECT ID, device, user_agent, ip, created_at, last_seen_at, expires_at, revoked_at "+
+			"FROM Sessions WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	identities, err := exportRows(manager.Database, []string{"provider", "subject", "email", "created_at"},
+		"SELECT provider, subject, email, created_at FROM Identities WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	apiKeys, err := exportRows(manager.Database,
+		[]string{"name", "hint", "scopes", "expires_at", "last_used_at", "created_at", "revoked_at"},
+		"SELECT name, hint, array_to_string(scopes, ','), expires_at, last_used_at, created_at, revoked_at "+
+			"FROM APIKeys WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	failures, err := exportRows(manager.Database, []string{"key", "failures", "locked_until", "updated_at"},
+		"SELECT key, failures, locked_until, updated_at FROM AuthFailures WHERE key = $1 OR key = $2",
+		lockout.AccountKey(account.Email), lockout.RestoreKey(account.Email))
+	if err != nil {
+		return nil, err
+	}
+	emailChanges, err := exportRows(manager.Database, []string{"new_email", "expires_at", "created_at"},
+		"SELECT new_email, expires_at, created_at FROM EmailChanges WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	magicLinks, err := exportRows(manager.Database, []string{"expires_at", "used_at"},
+		"SELECT expires_at, used_at FROM MagicLinks WHERE user_id = $1 ORDER BY expires_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := exportRows(manager.Database, []string{"name", "joined_at"},
+		"SELECT g.name, m.joined_at FROM GroupMembers m JOIN Groups g ON g.ID = m.group_id "+
+			"WHERE m.user_id = $1 ORDER BY m.joined_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	redemptions, err := exportRows(manager.Database, []string{"invite_hint", "role", "group", "redeemed_at"},
+		"SELECT i.hint, i.role, COALESCE(g.name, ''), r.redeemed_at FROM InviteRedemptions r "+
+			"JOIN Invites i ON i.ID = r.invite_id LEFT JOIN Groups g ON g.ID = i.group_id "+
+			"WHERE r.user_id = $1 ORDER BY r.redeemed_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	invites, err := exportRows(manager.Database,
+		[]string{"hint", "email", "role", "group", "max_uses", "uses", "expires_at", "created_at", "revoked_at"},
+		"SELECT i.hint, i.email, i.role, COALESCE(g.name, ''), i.max_uses, i.uses, i.expires_at, i.created_at, "+
+			"i.revoked_at FROM Invites i LEFT JOIN Groups g ON g.ID = i.group_id WHERE i.created_by = $1 ORDER BY i.ID", userID)
+	if err != nil {
+		return nil, err
+	}
+	avatar, err := exportRows(manager.Database, []string{"png_base64", "created_at"},
+		"SELECT encode(data, 'base64'), created_at FROM Avatars WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := exportRows(manager.Database,
+		[]string{"event", "actor", "ip", "user_agent", "details", "created_at"},
+		"SELECT event, actor, ip, user_agent, details, created_at FROM AuditLog WHERE user_id = $1 ORDER BY ID", userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := manager.GetProfile(userID)
+	if err != nil {
+		return nil, err
+	}
+	return Export{
+		"account":       account,
+		"profile":       profile,
+		"sessions":      sessions,
+		"identities":    identities,
+		"api_keys":      apiKeys,
+		"auth_failures": failures,
+		"email_changes": emailChanges,
+		"magic_links":   magicLinks,
+		"groups":        groups,
+		"redemptions":   redemptions,
+		"invites":       invites,
+		"avatar":        avatar,
+		"audit_log":     events,
+	}, nil
+}
+
+// exportRows runs a query and returns its rows as objects, columns are named by given keys in order
+func exportRows(db *sql.DB, keys []string, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Println("exportRows error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	result := make([]map[string]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(keys))
+		pointers := make([]interface{}, len(keys))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err = rows.Scan(pointers...); err != nil {
+			log.Println("exportRows error: " + err.Error())
+			return nil, ErrInternal
+		}
+		object := make(map[string]interface{}, len(keys))
+		for i, key := range keys {
+			if data, ok := values[i].([]byte); ok {
+				values[i] = string(data)
+			}
+			object[key] = values[i]
+		}
+		result = append(result, object)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("exportRows error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return result, nil
+}
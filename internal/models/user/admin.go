@@ -0,0 +1,218 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/lib/pq"
+)
+
+// Account is an user as seen by administrators
+type Account struct {
+	ID                    int        `json:"id"`
+	Email                 string     `json:"email"`
+	Role                  string     `json:"role"`
+	EmailVerified         bool       `json:"email_verified"`
+	TOTPEnabled           bool       `json:"totp_enabled"`
+	Disabled              bool       `json:"disabled"`
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	CreatedAt             time.Time  `json:"created_at"`
+	LastLoginAt           *time.Time `json:"last_login_at"`
+}
+
+// AccountFilter narrows down listed accounts, zero fields match everything
+type AccountFilter struct {
+	// EmailPrefix matches accounts whose email starts with it ignoring case
+	EmailPrefix string
+	// Sort is "created_at" for the oldest first or "-created_at" for the newest first, accounts are ordered by id otherwise
+	Sort string
+}
+
+const accountColumns = "ID, email, role, email_verified, totp_enabled, disabled_at IS NOT NULL, password_reset_required, " +
+	"created_at, last_login_at"
+
+// accountOrders maps sort orders to ORDER BY clauses, the id breaks ties so pages don't overlap
+var accountOrders = map[string]string{
+	"":            "ID",
+	"created_at":  "created_at, ID",
+	"-created_at": "created_at DESC, ID DESC",
+}
+
+// likeEscaper escapes LIKE wildcards so a prefix is matched literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likePrefix returns a LIKE pattern matching strings starting with a prefix
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
+
+// ListAccounts returns a page of accounts matching a filter along with the total number of them
+func (manager *Manager) ListAccounts(filter *AccountFilter, offset, limit int) ([]Account, int, error) {
+	order, ok := accountOrders[filter.Sort]
+	if !ok {
+		return nil, 0, ErrInvalidSort
+	}
+	pattern := likePrefix(strings.ToLower(filter.EmailPrefix))
+	var total int
+	row := manager.Database.QueryRow("SELECT COUNT(*) FROM Users WHERE LOWER(email) LIKE $1", pattern)
+	if err := row.Scan(&total); err != nil {
+		log.Println("manager.ListAccounts error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	rows, err := manager.Database.Query("SELECT "+accountColumns+" FROM Users WHERE LOWER(email) LIKE $1 "+
+		"ORDER BY "+order+" OFFSET $2 LIMIT $3", pattern, offset, limit)
+	if err != nil {
+		log.Println("manager.ListAccounts error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	defer rows.Close()
+	accounts := make([]Account, 0)
+	for rows.Next() {
+		var account Account
+		if err = scanAccount(rows, &account); err != nil {
+			log.Println("manager.ListAccounts error: " + err.Error())
+			return nil, 0, ErrInternal
+		}
+		accounts = append(accounts, account)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("manager.ListAccounts error: " + err.Error())
+		return nil, 0, ErrInternal
+	}
+	return accounts, total, nil
+}
+
+// GetAccount returns an account by id
+func (manager *Manager) GetAccount(id int) (*Account, error) {
+	var account Account
+	row := manager.Database.QueryRow("SELECT "+accountColumns+" FROM Users WHERE ID = $1", id)
+	if err := scanAccount(row, &account); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoUser
+		}
+		log.Println("manager.GetAccount error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return &account, nil
+}
+
+// scanAccount scans a row selecting accountColumns
+func scanAccount(row interface{ Scan(...interface{}) error }, account *Account) error {
+	return row.Scan(&account.ID, &account.Email, &account.Role, &account.EmailVerified, &account.TOTPEnabled,
+		&account.Disabled, &account.PasswordResetRequired, &account.CreatedAt, &account.LastLoginAt)
+}
+
+// SetRole changes a role of an user, the user is logged out everywhere so new tokens carry the new role
+func (manager *Manager) SetRole(id int, role string) error {
+	if !userauth.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	return manager.updateAndLogout("UPDATE Users SET role = $2 WHERE ID = $1 RETURNING ID", id, role)
+}
+
+// Disable prevents an user from logging in and logs the user out everywhere
+func (manager *Manager) Disable(id int) error {
+	return manager.updateAndLogout("UPDATE Users SET disabled_at = COALESCE(disabled_at, NOW()) "+
+		"WHERE ID = $1 RETURNING ID", id)
+}
+
+// Enable lets a disabled user log in again
+func (manager *Manager) Enable(id int) error {
+	var updated int
+	err := manager.Database.QueryRow("UPDATE Users SET disabled_at = NULL WHERE ID = $1 RETURNING ID", id).Scan(&updated)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoUser
+		}
+		log.Println("manager.Enable error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// ForceLogout revokes all sessions of an user along with access tokens issued so far, including ones without a session
+func (manager *Manager) ForceLogout(id int) error {
+	return manager.updateAndLogout("SELECT ID FROM Users WHERE ID = $1 FOR UPDATE", id)
+}
+
+// GetImpersonated returns a model of an user an administrator is going to act as,
+// administrators and disabled users can't be impersonated
+func (manager *Manager) GetImpersonated(id int) (*Model, error) {
+	account, err := manager.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Role == userauth.RoleAdmin || account.Disabled {
+		return nil, ErrImpersonationDenied
+	}
+	return &Model{
+		ID:            account.ID,
+		Email:         account.Email,
+		EmailVerified: account.EmailVerified,
+		Role:          account.Role,
+	}, nil
+}
+
+// RequirePasswordReset logs an user out everywhere and denies logging in until the password is changed.
+// Accounts created through a provider have no password to change, so they can't be required to
+func (manager *Manager) RequirePasswordReset(id int) error {
+	var passwordless bool
+	row := manager.Database.QueryRow("SELECT password = $2 FROM Users WHERE ID = $1", id, noPassword)
+	if err := row.Scan(&passwordless); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoUser
+		}
+		log.Println("manager.RequirePasswordReset error: " + err.Error())
+		return ErrInternal
+	}
+	if passwordless {
+		return ErrNoPassword
+	}
+	return manager.updateAndLogout("UPDATE Users SET password_reset_required = TRUE WHERE ID = $1 RETURNING ID", id)
+}
+
+// PromoteAdmins gives the admin role to existing users with given emails
+func (manager *Manager) PromoteAdmins(emails []string) error {
+	if len(emails) == 0 {
+		return nil
+	}
+	_, err := manager.Database.Exec("UPDATE Users SET role = $1 WHERE email = ANY($2)", userauth.RoleAdmin, pq.Array(emails))
+	if err != nil {
+		log.Println("manager.PromoteAdmins error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// updateAndLogout runs an update of a single user returning its id, revokes all sessions of the user
+// and invalidates access tokens issued so far, including ones without a session
+func (manager *Manager) updateAndLogout(query string, args ...interface{}) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.updateAndLogout error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	var id int
+	if err = tx.QueryRow(query, args...).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoUser
+		}
+		log.Println("manager.updateAndLogout error: " + err.Error())
+		return ErrInternal
+	}
+	if err = revokeUserSessions(tx, id); err == nil {
+		_, err = tx.Exec("UPDATE Users SET tokens_valid_after = NOW() WHERE ID = $1", id)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.updateAndLogout error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package user
+
+import "testing"
+
+func TestLikePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		prefix   string
+		expected string
+	}{
+		{"", "%"},
+		{"ivan@", "ivan@%"},
+		{"a_b%c", `a\_b\%c%`},
+		{`back\slash`, `back\\slash%`},
+	} {
+		if got := likePrefix(tc.prefix); got != tc.expected {
+			t.Errorf("%q: got %q, expected %q", tc.prefix, got, tc.expected)
+		}
+	}
+}
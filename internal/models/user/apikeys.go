@@ -0,0 +1,165 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/dchest/uniuri"
+	"github.com/lib/pq"
+)
+
+const (
+	apiKeyLength = 40
+	// apiKeyPrefix makes keys recognizable, e.g. by secret scanners
+	apiKeyPrefix = "fa_"
+	// apiKeyHintLength is how many characters of a key are kept in plain text to tell keys apart
+	apiKeyHintLength = 8
+	maxAPIKeys       = 20
+)
+
+// APIKey describes a personal API key, the key itself is shown only once when it's created
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// IsAPIKey reports whether a token looks like a personal API key rather than a JWT
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// CreateAPIKey creates a personal API key of an user and returns its description along with the key.
+// Empty scopes grant access to everything the user can access, a nil expiration time means the key never expires
+func (manager *Manager) CreateAPIKey(userID int, name string, scopes []string, expiresAt *time.Time) (*APIKey, string, error) {
+	for _, scope := range scopes {
+		if !userauth.IsValidScope(scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+	if expiresAt != nil && expiresAt.Before(time.Now()) {
+		return nil, "", ErrInvalidExpiration
+	}
+	if scopes == nil {
+		scopes = []string{}
+	}
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.CreateAPIKey error: " + err.Error())
+		return nil, "", ErrInternal
+	}
+	defer tx.Rollback()
+	var count int
+	row := tx.QueryRow("SELECT (SELECT COUNT(*) FROM APIKeys a WHERE a.user_id = Users.ID AND a.revoked_at IS NULL) "+
+		"FROM Users WHERE ID = $1 FOR UPDATE", userID)
+	if err = row.Scan(&count); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrNoUser
+		}
+		log.Println("manager.CreateAPIKey error: " + err.Error())
+		return nil, "", ErrInternal
+	}
+	if count >= maxAPIKeys {
+		return nil, "", ErrTooManyAPIKeys
+	}
+	key := apiKeyPrefix + uniuri.NewLen(apiKeyLength)
+	apiKey := &APIKey{
+		Name:      truncate(name, 100),
+		Hint:      key[:len(apiKeyPrefix)+apiKeyHintLength],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+	row = tx.QueryRow("INSERT INTO APIKeys (user_id, name, hint, key_hash, scopes, expires_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING ID, created_at",
+		userID, apiKey.Name, apiKey.Hint, helpers.HashToken(key), pq.Array(scopes), expiresAt)
+	if err = row.Scan(&apiKey.ID, &apiKey.CreatedAt); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.CreateAPIKey error: " + err.Error())
+		return nil, "", ErrInternal
+	}
+	return apiKey, key, nil
+}
+
+// GetAPIKeys returns API keys of an user which haven't been revoked, newest first
+func (manager *Manager) GetAPIKeys(userID int) ([]APIKey, error) {
+	rows, err := manager.Database.Query("SELECT ID, name, hint, scopes, expires_at, last_used_at, created_at "+
+		"FROM APIKeys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC", userID)
+	if err != nil {
+		log.Println("manager.GetAPIKeys error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		var key APIKey
+		err = rows.Scan(&key.ID, &key.Name, &key.Hint, pq.Array(&key.Scopes), &key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt)
+		if err != nil {
+			log.Println("manager.GetAPIKeys error: " + err.Error())
+			return nil, ErrInternal
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("manager.GetAPIKeys error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey makes an API key of an user stop working
+func (manager *Manager) RevokeAPIKey(userID, id int) error {
+	result, err := manager.Database.Exec("UPDATE APIKeys SET revoked_at = NOW() WHERE ID = $1 AND revoked_at IS NULL AND "+
+		"user_id = $2", id, userID)
+	if err != nil {
+		log.Println("manager.RevokeAPIKey error: " + err.Error())
+		return ErrInternal
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoAPIKey
+	}
+	return nil
+}
+
+// GetAPIKeyClaims returns claims of an user who owns a given API key. It's meant to be passed to userauth.WithAPIKeys
+func (manager *Manager) GetAPIKeyClaims(key string) (*userauth.Claims, error) {
+	var (
+		id       int
+		userID   int
+		disabled bool
+		claims   = &userauth.Claims{}
+	)
+	row := manager.Database.QueryRow("SELECT a.ID, a.scopes, u.ID, u.email, u.email_verified, u.role, u.disabled_at IS NOT NULL "+
+		"FROM APIKeys a JOIN Users u ON u.ID = a.user_id WHERE a.key_hash = $1 AND a.revoked_at IS NULL AND "+
+		"(a.expires_at IS NULL OR a.expires_at > NOW())", helpers.HashToken(key))
+	err := row.Scan(&id, pq.Array(&claims.Scopes), &userID, &claims.Email, &claims.EmailVerified, &claims.Role, &disabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidAPIKey
+		}
+		log.Println("manager.GetAPIKeyClaims error: " + err.Error())
+		return nil, ErrInternal
+	}
+	if disabled {
+		return nil, ErrAccountDisabled
+	}
+	claims.Id = strconv.Itoa(id)
+	claims.Purpose = userauth.APIKeyPurpose
+	claims.SetUserID(userID)
+	_, err = manager.Database.Exec("UPDATE APIKeys SET last_used_at = NOW() WHERE ID = $1 AND "+
+		"(last_used_at IS NULL OR last_used_at < NOW() - $2 * INTERVAL '1 second')", id, int(lastSeenPeriod.Seconds()))
+	if err != nil {
+		log.Println("manager.GetAPIKeyClaims error: " + err.Error())
+	}
+	return claims, nil
+}
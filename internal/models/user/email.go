@@ -0,0 +1,96 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/dchest/uniuri"
+)
+
+const emailChangeIDLength = 32
+
+// RequestEmailChange checks the password of an user, or that an user without one has just signed in with the session,
+// and records a pending change of the email address, it returns the change id. A previously requested change is replaced
+func (manager *Manager) RequestEmailChange(id int, sessionID, password, newEmail string) (string, error) {
+	if err := manager.reauthenticate(id, sessionID, password); err != nil {
+		return "", err
+	}
+	if manager.IsEmailRegistered(newEmail) {
+		return "", ErrEmailRegistered
+	}
+	changeID := uniuri.NewLen(emailChangeIDLength)
+	_, err := manager.Database.Exec("INSERT INTO EmailChanges (ID, user_id, new_email, expires_at) "+
+		"VALUES ($2, $1, $3, NOW() + $4 * INTERVAL '1 second') "+
+		"ON CONFLICT (user_id) DO UPDATE SET ID = EXCLUDED.ID, new_email = EXCLUDED.new_email, "+
+		"expires_at = EXCLUDED.expires_at, created_at = NOW()",
+		id, changeID, newEmail, int(emailChangeLifespan.Seconds()))
+	if err != nil {
+		log.Println("manager.RequestEmailChange error: " + err.Error())
+		return "", ErrInternal
+	}
+	return changeID, nil
+}
+
+// ConfirmEmailChange swaps the email address of an user for the confirmed one and returns the old and the new address.
+// The user is logged out everywhere and links sent to the old address stop working
+func (manager *Manager) ConfirmEmailChange(changeID string) (string, string, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.ConfirmEmailChange error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	defer tx.Rollback()
+	var (
+		userID   int
+		oldEmail string
+		newEmail string
+	)
+	row := tx.QueryRow("SELECT u.ID, u.email, c.new_email FROM EmailChanges c JOIN Users u ON u.ID = c.user_id "+
+		"WHERE c.ID = $1 AND c.expires_at > NOW() FOR UPDATE", changeID)
+	if err = row.Scan(&userID, &oldEmail, &newEmail); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrNoEmailChange
+		}
+		log.Println("manager.ConfirmEmailChange error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	var registered bool
+	row = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Users WHERE email = $1)", newEmail)
+	if err = row.Scan(&registered); err != nil {
+		log.Println("manager.ConfirmEmailChange error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	if registered {
+		return "", "", ErrEmailRegistered
+	}
+	if err = revokeUserSessions(tx, userID); err == nil {
+		_, err = tx.Exec("UPDATE Users SET email = $1, email_verified = TRUE WHERE ID = $2", newEmail, userID)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM EmailChanges WHERE user_id = $1", userID)
+	}
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM MagicLinks WHERE user_id = $1", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.ConfirmEmailChange error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	return oldEmail, newEmail, nil
+}
+
+// CancelEmailChange drops a pending change of an email address
+func (manager *Manager) CancelEmailChange(changeID string) error {
+	result, err := manager.Database.Exec("DELETE FROM EmailChanges WHERE ID = $1", changeID)
+	if err != nil {
+		log.Println("manager.CancelEmailChange error: " + err.Error())
+		return ErrInternal
+	}
+	if deleted, _ := result.RowsAffected(); deleted == 0 {
+		return ErrNoEmailChange
+	}
+	return nil
+}
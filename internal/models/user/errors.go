@@ -3,9 +3,49 @@ package user
 import "errors"
 
 var (
-	ErrEmailRegistered = errors.New("the provided email is registered")
-	ErrInternal        = errors.New("internal error")
-	ErrInvalidToken    = errors.New("an invalid auth token provided")
-	ErrNotMatched      = errors.New("the provided password doesn't match the account password")
-	ErrNoUser          = errors.New("no user with the given email found")
+	ErrEmailRegistered       = errors.New("the provided email is registered")
+	ErrInternal              = errors.New("internal error")
+	ErrInvalidToken          = errors.New("an invalid auth token provided")
+	ErrNotMatched            = errors.New("the provided password doesn't match the account password")
+	ErrNoUser                = errors.New("no user with the given email found")
+	ErrInvalidRefresh        = errors.New("an invalid refresh token provided")
+	ErrInvalidRestoreCode    = errors.New("invalid code provided")
+	ErrTOTPEnabled           = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled       = errors.New("two-factor authentication is not enabled")
+	ErrInvalidTOTPCode       = errors.New("an invalid two-factor code provided")
+	ErrInvalidOAuthState     = errors.New("an invalid or expired oauth state provided")
+	ErrIdentityConflict      = errors.New("the email is registered and the provider hasn't verified it")
+	ErrNoIdentityEmail       = errors.New("the provider hasn't shared an email address")
+	ErrUnverifiedAccount     = errors.New("the email is registered to an unverified account, verify it before linking a provider")
+	ErrNoSession             = errors.New("no session with the given id found")
+	ErrSessionRevoked        = errors.New("the session has been revoked")
+	ErrRefreshReused         = errors.New("the refresh token has already been used, all related tokens are revoked")
+	ErrAccountDisabled       = errors.New("the account has been disabled")
+	ErrPasswordResetRequired = errors.New("the password must be changed before logging in")
+	ErrInvalidRole           = errors.New("invalid role provided")
+	ErrInvalidScope          = errors.New("invalid scope provided")
+	ErrInvalidExpiration     = errors.New("the expiration time has already passed")
+	ErrTooManyAPIKeys        = errors.New("too many api keys, revoke unused ones first")
+	ErrNoAPIKey              = errors.New("no api key with the given id found")
+	ErrInvalidAPIKey         = errors.New("invalid api key provided")
+	ErrInvalidMagicLink      = errors.New("the link is invalid or has already been used")
+	ErrNoEmailChange         = errors.New("the email change is invalid or has already been completed")
+	ErrNoDeletion            = errors.New("the account isn't scheduled for deletion")
+	ErrInvalidDisplayName    = errors.New("the display name must be at most 50 printable characters")
+	ErrInvalidLocale         = errors.New("unsupported locale provided")
+	ErrInvalidTimeZone       = errors.New("unknown time zone provided")
+	ErrInvalidExamYear       = errors.New("the exam year is out of range")
+	ErrNoAvatar              = errors.New("the user has no avatar")
+	ErrTokenRevoked          = errors.New("the token has been revoked")
+	ErrForbiddenRole         = errors.New("the role can't be assigned by the user")
+	ErrInvalidMaxUses        = errors.New("max uses must be between 1 and 1000")
+	ErrInvalidGroup          = errors.New("the group name must be at most 100 characters")
+	ErrForeignGroup          = errors.New("the group belongs to another user")
+	ErrNoInvite              = errors.New("no invite with the given id found")
+	ErrInvalidInvite         = errors.New("the invite code is invalid, expired or used up")
+	ErrInviteRequired        = errors.New("an invite code is required to sign up")
+	ErrInvalidSort           = errors.New("invalid sort order provided")
+	ErrImpersonationDenied   = errors.New("administrators and disabled accounts can't be impersonated")
+	ErrNoPassword            = errors.New("the account signs in through a provider and has no password")
+	ErrReauthRequired        = errors.New("sign in through the provider again to confirm the action")
 )
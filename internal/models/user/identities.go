@@ -0,0 +1,110 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	oauthStateLength   = 32
+	oauthNonceLength   = 32
+	oauthStateLifespan = time.Minute * 10
+	// noPassword is stored for accounts created through a provider, it never matches any password hash
+	noPassword = "!"
+)
+
+// CreateOAuthState stores a PKCE verifier and a nonce of a started login and returns a state identifying them
+func (manager *Manager) CreateOAuthState(provider, verifier string) (state, nonce string, err error) {
+	state = uniuri.NewLen(oauthStateLength)
+	nonce = uniuri.NewLen(oauthNonceLength)
+	_, err = manager.Database.Exec("DELETE FROM OAuthStates WHERE expires_at < NOW()")
+	if err == nil {
+		_, err = manager.Database.Exec("INSERT INTO OAuthStates (state, provider, code_verifier, nonce, expires_at) "+
+			"VALUES ($1, $2, $3, $4, $5)", state, provider, verifier, nonce, time.Now().Add(oauthStateLifespan))
+	}
+	if err != nil {
+		log.Println("manager.CreateOAuthState error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	return state, nonce, nil
+}
+
+// ConsumeOAuthState returns a PKCE verifier and a nonce of a started login, a state can be used only once
+func (manager *Manager) ConsumeOAuthState(provider, state string) (verifier, nonce string, err error) {
+	row := manager.Database.QueryRow("DELETE FROM OAuthStates WHERE state = $1 AND provider = $2 AND expires_at > NOW() "+
+		"RETURNING code_verifier, nonce", state, provider)
+	if err = row.Scan(&verifier, &nonce); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrInvalidOAuthState
+		}
+		log.Println("manager.ConsumeOAuthState error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	return verifier, nonce, nil
+}
+
+// GetByIdentity returns an user linked to a provider identity. An unknown identity is linked to an account
+// with the same email if both the provider and the account have verified it, otherwise a new account is created
+// unless signups are closed. An unverified account may have been registered by someone else who knows its password,
+// so it isn't linked
+func (manager *Manager) GetByIdentity(provider, subject, email string, emailVerified, allowSignup bool) (*Model, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.GetByIdentity error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer tx.Rollback()
+	model := &Model{}
+	row := tx.QueryRow("SELECT u.ID, u.email, u.email_verified, u.totp_enabled, u.role FROM Identities i "+
+		"JOIN Users u ON u.ID = i.user_id WHERE i.provider = $1 AND i.subject = $2", provider, subject)
+	err = row.Scan(&model.ID, &model.Email, &model.EmailVerified, &model.TOTPEnabled, &model.Role)
+	if err == nil {
+		return model, nil
+	}
+	if err != sql.ErrNoRows {
+		log.Println("manager.GetByIdentity error: " + err.Error())
+		return nil, ErrInternal
+	}
+	if email == "" {
+		return nil, ErrNoIdentityEmail
+	}
+	var userID int
+	row = tx.QueryRow("SELECT ID, email_verified, totp_enabled, role FROM Users WHERE email = $1", email)
+	err = row.Scan(&userID, &model.EmailVerified, &model.TOTPEnabled, &model.Role)
+	switch {
+	case err == sql.ErrNoRows && !allowSignup:
+		return nil, ErrInviteRequired
+	case err == sql.ErrNoRows:
+		row = tx.QueryRow("INSERT INTO Users (email, password, email_verified) VALUES ($1, $2, $3) RETURNING ID",
+			email, noPassword, emailVerified)
+		if err = row.Scan(&userID); err != nil {
+			log.Println("manager.GetByIdentity error: " + err.Error())
+			return nil, ErrInternal
+		}
+		model.EmailVerified = emailVerified
+		model.Role = userauth.RoleStudent
+	case err != nil:
+		log.Println("manager.GetByIdentity error: " + err.Error())
+		return nil, ErrInternal
+	case !emailVerified:
+		return nil, ErrIdentityConflict
+	case !model.EmailVerified:
+		return nil, ErrUnverifiedAccount
+	}
+	_, err = tx.Exec("INSERT INTO Identities (user_id, provider, subject, email) VALUES ($1, $2, $3, $4)",
+		userID, provider, subject, email)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.GetByIdentity error: " + err.Error())
+		return nil, ErrInternal
+	}
+	model.ID = userID
+	model.Email = email
+	return model, nil
+}
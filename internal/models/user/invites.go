@@ -0,0 +1,322 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	inviteCodeLength = 16
+	// inviteCodeChars leave out characters which are easy to confuse when a code is typed by hand
+	inviteCodeChars       = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	inviteCodeHintLength  = 4
+	defaultInviteLifespan = time.Hour * 24 * 7
+	maxInviteUses         = 1000
+	maxGroupNameLength    = 100
+)
+
+// InviteOptions describe an invite being created
+type InviteOptions struct {
+	// Email binds the invite to an address if it isn't empty
+	Email string
+	// Role is assigned to users who sign up with the invite, students are invited if it's empty
+	Role string
+	// Group is a name of a group users who sign up with the invite join, the group is created if there's none
+	Group string
+	// MaxUses is how many users can sign up with the invite, one if it's zero
+	MaxUses int
+	// ExpiresAt defaults to a week from now if it's nil
+	ExpiresAt *time.Time
+}
+
+// Invite describes an invite code, the code itself is shown only once when it's created
+type Invite struct {
+	ID        int        `json:"id"`
+	Hint      string     `json:"hint"`
+	Email     string     `json:"email,omitempty"`
+	Role      string     `json:"role"`
+	Group     string     `json:"group,omitempty"`
+	MaxUses   int        `json:"max_uses"`
+	Uses      int        `json:"uses"`
+	CreatedBy string     `json:"created_by"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// Redemption is a signup with an invite
+type Redemption struct {
+	UserID     int       `json:"user_id"`
+	Email      string    `json:"email"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+}
+
+// normalizeInviteCode makes codes case-insensitive
+func normalizeInviteCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// prepare fills in defaults of options and checks them against the role of an user creating the invite at a given time,
+// it returns the time the invite expires at
+func (options *InviteOptions) prepare(creatorRole string, now time.Time) (time.Time, error) {
+	if options.Role == "" {
+		options.Role = userauth.RoleStudent
+	}
+	if !userauth.IsValidRole(options.Role) {
+		return time.Time{}, ErrInvalidRole
+	}
+	if creatorRole != userauth.RoleAdmin && options.Role != userauth.RoleStudent {
+		return time.Time{}, ErrForbiddenRole
+	}
+	if options.MaxUses == 0 {
+		options.MaxUses = 1
+	}
+	if options.MaxUses < 0 || options.MaxUses > maxInviteUses {
+		return time.Time{}, ErrInvalidMaxUses
+	}
+	expiresAt := now.Add(defaultInviteLifespan)
+	if options.ExpiresAt != nil {
+		if options.ExpiresAt.Before(now) {
+			return time.Time{}, ErrInvalidExpiration
+		}
+		expiresAt = *options.ExpiresAt
+	}
+	options.Email = strings.TrimSpace(options.Email)
+	options.Group = strings.TrimSpace(options.Group)
+	if len([]rune(options.Group)) > maxGroupNameLength {
+		return time.Time{}, ErrInvalidGroup
+	}
+	return expiresAt, nil
+}
+
+// CreateInvite creates an invite on behalf of an user with a given role and returns its description along with the code.
+// Teachers can invite only students and only into their own groups
+func (manager *Manager) CreateInvite(creatorID int, creatorRole string, options *InviteOptions) (*Invite, string, error) {
+	expiresAt, err := options.prepare(creatorRole, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	group := options.Group
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.CreateInvite error: " + err.Error())
+		return nil, "", ErrInternal
+	}
+	defer tx.Rollback()
+	var creatorEmail string
+	if err = tx.QueryRow("SELECT email FROM Users WHERE ID = $1", creatorID).Scan(&creatorEmail); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", ErrNoUser
+		}
+		log.Println("manager.CreateInvite error: " + err.Error())
+		return nil, "", ErrInternal
+	}
+	var groupID *int
+	if group != "" {
+		groupID = new(int)
+		var ownerID *int
+		// the no-op update makes RETURNING work for an existing group
+		row := tx.QueryRow("INSERT INTO Groups (name, created_by) VALUES ($1, $2) "+
+			"ON CONFLICT (name) DO UPDATE SET name = EXCLUDED.name RETURNING ID, created_by", group, creatorID)
+		if err = row.Scan(groupID, &ownerID); err != nil {
+			log.Println("manager.CreateInvite error: " + err.Error())
+			return nil, "", ErrInternal
+		}
+		if creatorRole != userauth.RoleAdmin && (ownerID == nil || *ownerID != creatorID) {
+			return nil, "", ErrForeignGroup
+		}
+	}
+	code := uniuri.NewLenChars(inviteCodeLength, []byte(inviteCodeChars))
+	invite := &Invite{
+		Hint:      code[:inviteCodeHintLength],
+		Email:     options.Email,
+		Role:      options.Role,
+		Group:     group,
+		MaxUses:   options.MaxUses,
+		CreatedBy: creatorEmail,
+		ExpiresAt: expiresAt,
+	}
+	row := tx.QueryRow("INSERT INTO Invites (code_hash, hint, created_by, email, role, group_id, max_uses, expires_at) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING ID, created_at",
+		helpers.HashToken(code), invite.Hint, creatorID, invite.Email, invite.Role, groupID, invite.MaxUses, expiresAt)
+	if err = row.Scan(&invite.ID, &invite.CreatedAt); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.CreateInvite error: " + err.Error())
+		return nil, "", ErrInternal
+	}
+	return invite, code, nil
+}
+
+// GetInvites returns invites an user with a given role can manage, newest first.
+// Admins manage every invite, teachers manage their own ones
+func (manager *Manager) GetInvites(userID int, role string) ([]Invite, error) {
+	rows, err := manager.Database.Query("SELECT i.ID, i.hint, i.email, i.role, COALESCE(g.name, ''), i.max_uses, i.uses, "+
+		"COALESCE(u.email, ''), i.expires_at, i.created_at, i.revoked_at FROM Invites i "+
+		"LEFT JOIN Groups g ON g.ID = i.group_id LEFT JOIN Users u ON u.ID = i.created_by "+
+		"WHERE $2 OR i.created_by = $1 ORDER BY i.ID DESC", userID, role == userauth.RoleAdmin)
+	if err != nil {
+		log.Println("manager.GetInvites error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	invites := make([]Invite, 0)
+	for rows.Next() {
+		var invite Invite
+		err = rows.Scan(&invite.ID, &invite.Hint, &invite.Email, &invite.Role, &invite.Group, &invite.MaxUses,
+			&invite.Uses, &invite.CreatedBy, &invite.ExpiresAt, &invite.CreatedAt, &invite.RevokedAt)
+		if err != nil {
+			log.Println("manager.GetInvites error: " + err.Error())
+			return nil, ErrInternal
+		}
+		invites = append(invites, invite)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("manager.GetInvites error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return invites, nil
+}
+
+// RevokeInvite makes an invite stop working, users who have already signed up with it keep their accounts
+func (manager *Manager) RevokeInvite(userID int, role string, id int) error {
+	result, err := manager.Database.Exec("UPDATE Invites SET revoked_at = NOW() WHERE ID = $1 AND revoked_at IS NULL "+
+		"AND ($3 OR created_by = $2)", id, userID, role == userauth.RoleAdmin)
+	if err != nil {
+		log.Println("manager.RevokeInvite error: " + err.Error())
+		return ErrInternal
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoInvite
+	}
+	return nil
+}
+
+// GetRedemptions returns signups with an invite an user with a given role can manage
+func (manager *Manager) GetRedemptions(userID int, role string, id int) ([]Redemption, error) {
+	var exists bool
+	row := manager.Database.QueryRow("SELECT EXISTS(SELECT 1 FROM Invites WHERE ID = $1 AND ($3 OR created_by = $2))",
+		id, userID, role == userauth.RoleAdmin)
+	if err := row.Scan(&exists); err != nil {
+		log.Println("manager.GetRedemptions error: " + err.Error())
+		return nil, ErrInternal
+	}
+	if !exists {
+		return nil, ErrNoInvite
+	}
+	rows, err := manager.Database.Query("SELECT u.ID, u.email, r.redeemed_at FROM InviteRedemptions r "+
+		"JOIN Users u ON u.ID = r.user_id WHERE r.invite_id = $1 ORDER BY r.redeemed_at", id)
+	if err != nil {
+		log.Println("manager.GetRedemptions error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	redemptions := make([]Redemption, 0)
+	for rows.Next() {
+		var redemption Redemption
+		if err = rows.Scan(&redemption.UserID, &redemption.Email, &redemption.RedeemedAt); err != nil {
+			log.Println("manager.GetRedemptions error: " + err.Error())
+			return nil, ErrInternal
+		}
+		redemptions = append(redemptions, redemption)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("manager.GetRedemptions error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return redemptions, nil
+}
+
+// inviteState holds what decides whether an invite can be redeemed
+type inviteState struct {
+	uses      int
+	maxUses   int
+	expiresAt time.Time
+	revoked   bool
+	// email the invite is bound to, anyone can redeem it if it's empty
+	email string
+}
+
+// check returns an error if an invite can't be redeemed by an user with a given email at a given time
+func (state *inviteState) check(email string, now time.Time) error {
+	if state.revoked || state.uses >= state.maxUses || !now.Before(state.expiresAt) {
+		return ErrInvalidInvite
+	}
+	if state.email != "" && !strings.EqualFold(state.email, email) {
+		return ErrInvalidInvite
+	}
+	return nil
+}
+
+// CreateWithInvite creates a new user redeeming an invite code, the user gets the invite role and joins its group.
+// The invite is locked until the transaction ends, so concurrent signups can't redeem it more times than allowed
+func (manager *Manager) CreateWithInvite(email, plainPassword, code string) (*Model, error) {
+	if err := manager.PasswordPolicy.Validate("password", email, plainPassword); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := manager.PasswordHasher.Hash(plainPassword)
+	if err != nil {
+		log.Println("manager.CreateWithInvite error: " + err.Error())
+		return nil, ErrInternal
+	}
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.CreateWithInvite error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer tx.Rollback()
+	var (
+		inviteID int
+		invite   inviteState
+		groupID  *int
+		userID   int
+		model    = New(email)
+	)
+	row := tx.QueryRow("SELECT ID, email, role, group_id, uses, max_uses, expires_at, revoked_at IS NOT NULL "+
+		"FROM Invites WHERE code_hash = $1 FOR UPDATE", helpers.HashToken(normalizeInviteCode(code)))
+	err = row.Scan(&inviteID, &invite.email, &model.Role, &groupID, &invite.uses, &invite.maxUses, &invite.expiresAt,
+		&invite.revoked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidInvite
+		}
+		log.Println("manager.CreateWithInvite error: " + err.Error())
+		return nil, ErrInternal
+	}
+	if err = invite.check(email, time.Now()); err != nil {
+		return nil, err
+	}
+	row = tx.QueryRow("INSERT INTO Users (email, password, role) VALUES ($1, $2, $3) ON CONFLICT (email) DO NOTHING "+
+		"RETURNING ID", email, hashedPassword, model.Role)
+	if err = row.Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEmailRegistered
+		}
+		log.Println("manager.CreateWithInvite error: " + err.Error())
+		return nil, ErrInternal
+	}
+	_, err = tx.Exec("UPDATE Invites SET uses = uses + 1 WHERE ID = $1", inviteID)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO InviteRedemptions (invite_id, user_id) VALUES ($1, $2)", inviteID, userID)
+	}
+	if err == nil && groupID != nil {
+		_, err = tx.Exec("INSERT INTO GroupMembers (group_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			*groupID, userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.CreateWithInvite error: " + err.Error())
+		return nil, ErrInternal
+	}
+	model.ID = userID
+	return model, nil
+}
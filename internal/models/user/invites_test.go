@@ -0,0 +1,84 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+)
+
+func TestInviteOptionsPrepare(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	for _, tc := range []struct {
+		name        string
+		creatorRole string
+		options     InviteOptions
+		expected    error
+	}{
+		{"student invite by a teacher", userauth.RoleTeacher, InviteOptions{}, nil},
+		{"teacher invite by a teacher", userauth.RoleTeacher, InviteOptions{Role: userauth.RoleTeacher}, ErrForbiddenRole},
+		{"teacher invite by an admin", userauth.RoleAdmin, InviteOptions{Role: userauth.RoleTeacher}, nil},
+		{"unknown role", userauth.RoleAdmin, InviteOptions{Role: "owner"}, ErrInvalidRole},
+		{"negative max uses", userauth.RoleAdmin, InviteOptions{MaxUses: -1}, ErrInvalidMaxUses},
+		{"too many max uses", userauth.RoleAdmin, InviteOptions{MaxUses: maxInviteUses + 1}, ErrInvalidMaxUses},
+		{"expired", userauth.RoleAdmin, InviteOptions{ExpiresAt: &past}, ErrInvalidExpiration},
+		{"expiring later", userauth.RoleAdmin, InviteOptions{ExpiresAt: &future}, nil},
+		{"long group name", userauth.RoleAdmin, InviteOptions{Group: strings.Repeat("я", maxGroupNameLength+1)},
+			ErrInvalidGroup},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := tc.options.prepare(tc.creatorRole, now); err != tc.expected {
+				t.Errorf("got: %v, expected: %v", err, tc.expected)
+			}
+		})
+	}
+	t.Run("defaults", func(t *testing.T) {
+		options := InviteOptions{Email: " ivan@mail.ru ", Group: " 11A "}
+		expiresAt, err := options.prepare(userauth.RoleTeacher, now)
+		if err != nil {
+			t.Fatal("prepare returns an error:", err)
+		}
+		if options.Role != userauth.RoleStudent || options.MaxUses != 1 || options.Email != "ivan@mail.ru" ||
+			options.Group != "11A" {
+			t.Errorf("got options: %+v", options)
+		}
+		if !expiresAt.Equal(now.Add(defaultInviteLifespan)) {
+			t.Errorf("got expiration: %v, expected: %v", expiresAt, now.Add(defaultInviteLifespan))
+		}
+	})
+}
+
+func TestInviteStateCheck(t *testing.T) {
+	now := time.Now()
+	after := now.Add(time.Hour)
+	for _, tc := range []struct {
+		name     string
+		state    inviteState
+		email    string
+		expected error
+	}{
+		{"unused invite", inviteState{maxUses: 1, expiresAt: after}, "ivan@mail.ru", nil},
+		{"last use left", inviteState{uses: 4, maxUses: 5, expiresAt: after}, "ivan@mail.ru", nil},
+		{"used up", inviteState{uses: 5, maxUses: 5, expiresAt: after}, "ivan@mail.ru", ErrInvalidInvite},
+		{"expired", inviteState{maxUses: 1, expiresAt: now}, "ivan@mail.ru", ErrInvalidInvite},
+		{"revoked", inviteState{maxUses: 1, expiresAt: after, revoked: true}, "ivan@mail.ru", ErrInvalidInvite},
+		{"bound to the email", inviteState{maxUses: 1, expiresAt: after, email: "Ivan@Mail.ru"}, "ivan@mail.ru", nil},
+		{"bound to another email", inviteState{maxUses: 1, expiresAt: after, email: "petr@mail.ru"}, "ivan@mail.ru",
+			ErrInvalidInvite},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.state.check(tc.email, now); err != tc.expected {
+				t.Errorf("got: %v, expected: %v", err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeInviteCode(t *testing.T) {
+	if code := normalizeInviteCode(" abcd2345efgh6789\n"); code != "ABCD2345EFGH6789" {
+		t.Errorf("got: %q, expected: %q", code, "ABCD2345EFGH6789")
+	}
+}
@@ -0,0 +1,62 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/dchest/uniuri"
+)
+
+const magicLinkIDLength = 32
+
+// CreateMagicLink registers a login link of an user and returns its id along with the user id,
+// previously issued links stop being valid
+func (manager *Manager) CreateMagicLink(email string) (string, int, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.CreateMagicLink error: " + err.Error())
+		return "", 0, ErrInternal
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM MagicLinks WHERE expires_at < NOW() OR "+
+		"user_id = (SELECT ID FROM Users WHERE email = $1)", email)
+	if err != nil {
+		log.Println("manager.CreateMagicLink error: " + err.Error())
+		return "", 0, ErrInternal
+	}
+	linkID := uniuri.NewLen(magicLinkIDLength)
+	var userID int
+	row := tx.QueryRow("INSERT INTO MagicLinks (ID, user_id, expires_at) "+
+		"SELECT $2, ID, NOW() + $3 * INTERVAL '1 second' FROM Users WHERE email = $1 AND disabled_at IS NULL "+
+		"RETURNING user_id", email, linkID, int(magicLinkLifespan.Seconds()))
+	if err = row.Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, ErrNoUser
+		}
+		log.Println("manager.CreateMagicLink error: " + err.Error())
+		return "", 0, ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("manager.CreateMagicLink error: " + err.Error())
+		return "", 0, ErrInternal
+	}
+	return linkID, userID, nil
+}
+
+// ConsumeMagicLink uses up a login link and returns a model of the user it was sent to.
+// Following the link proves the user owns the email address, so it's marked as verified
+func (manager *Manager) ConsumeMagicLink(linkID string) (*Model, error) {
+	model := &Model{}
+	row := manager.Database.QueryRow("WITH link AS (UPDATE MagicLinks SET used_at = NOW() "+
+		"WHERE ID = $1 AND used_at IS NULL AND expires_at > NOW() RETURNING user_id) "+
+		"UPDATE Users SET email_verified = TRUE FROM link WHERE Users.ID = link.user_id "+
+		"RETURNING Users.ID, email, email_verified, totp_enabled, role", linkID)
+	if err := row.Scan(&model.ID, &model.Email, &model.EmailVerified, &model.TOTPEnabled, &model.Role); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidMagicLink
+		}
+		log.Println("manager.ConsumeMagicLink error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return model, nil
+}
@@ -4,77 +4,162 @@ import (
 	"database/sql"
 	"log"
 
+	"github.com/adjsky/fetchapp_server/internal/models/user/password"
+	"github.com/adjsky/fetchapp_server/internal/models/user/policy"
 	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
+// querier is implemented by both *sql.DB and *sql.Tx so queries can run either standalone or in a transaction
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // Manager manages user models
 type Manager struct {
-	Database *sql.DB
+	Database       *sql.DB
+	PasswordPolicy *policy.Policy
+	PasswordHasher *password.Hasher
 }
 
-// NewManager returns an user model manager
-func NewManager(db *sql.DB) *Manager {
+// NewManager returns an user model manager, new passwords are validated against a given policy and hashed with a given hasher
+func NewManager(db *sql.DB, passwordPolicy *policy.Policy, passwordHasher *password.Hasher) *Manager {
 	return &Manager{
-		Database: db,
+		Database:       db,
+		PasswordPolicy: passwordPolicy,
+		PasswordHasher: passwordHasher,
 	}
 }
 
 // Create creates a new user and returns a model
-func (manager *Manager) Create(email, password string) (*Model, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (manager *Manager) Create(email, plainPassword string) (*Model, error) {
+	if err := manager.PasswordPolicy.Validate("password", email, plainPassword); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := manager.PasswordHasher.Hash(plainPassword)
 	if err != nil {
+		log.Println("manager.Create error: " + err.Error())
 		return nil, ErrInternal
 	}
-	_, err = manager.Database.Exec("INSERT INTO Users (email, password) VALUES ($1, $2)", email, hashedPassword)
-	if err != nil {
+	model := New(email)
+	row := manager.Database.QueryRow("INSERT INTO Users (email, password) VALUES ($1, $2) RETURNING ID", email, hashedPassword)
+	if err = row.Scan(&model.ID); err != nil {
 		log.Println("manager.Create error: " + err.Error())
 		return nil, ErrEmailRegistered
 	}
-	return &Model{
-		Email: email,
-	}, nil
+	return model, nil
 }
 
-// MatchPassword checks whether the provided password matches and returns an user model
-func (manager *Manager) MatchPassword(email, password string) (*Model, error) {
-	var hashedPassword string
-	row := manager.Database.QueryRow("SELECT password FROM Users WHERE email = $1", email)
-	if err := row.Scan(&hashedPassword); err != nil {
+// MatchPassword checks whether the provided password of an user with a given email matches and returns an user model.
+// A matched password stored with a legacy algorithm or weaker parameters is rehashed
+func (manager *Manager) MatchPassword(email, plainPassword string) (*Model, error) {
+	return manager.matchPassword("email = $1", email, plainPassword)
+}
+
+// MatchPasswordByID checks whether the provided password of an user with a given id matches and returns an user model
+func (manager *Manager) MatchPasswordByID(id int, plainPassword string) (*Model, error) {
+	return manager.matchPassword("ID = $1", id, plainPassword)
+}
+
+func (manager *Manager) matchPassword(condition string, arg interface{}, plainPassword string) (*Model, error) {
+	var (
+		hashedPassword string
+		model          Model
+	)
+	row := manager.Database.QueryRow("SELECT ID, email, password, email_verified, totp_enabled, role FROM Users WHERE "+
+		condition, arg)
+	if err := row.Scan(&model.ID, &model.Email, &hashedPassword, &model.EmailVerified, &model.TOTPEnabled, &model.Role); err != nil {
 		log.Println("manager.MatchPassword error: " + err.Error())
 		if err == sql.ErrNoRows {
 			return nil, ErrNoUser
 		}
 		return nil, ErrInternal
 	}
-	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+	matched, rehash, err := manager.PasswordHasher.Verify(plainPassword, hashedPassword)
+	if !matched {
+		if err != nil && hashedPassword != noPassword {
+			log.Println("manager.MatchPassword error: " + err.Error())
+		}
 		return nil, ErrNotMatched
 	}
-	return &Model{
-		Email: email,
-	}, nil
+	if rehash {
+		manager.rehashPassword(model.ID, plainPassword, hashedPassword)
+	}
+	return &model, nil
+}
+
+// rehashPassword replaces a stored hash unless the password has been changed in the meantime, failures are only logged
+func (manager *Manager) rehashPassword(id int, plainPassword, oldHash string) {
+	newHash, err := manager.PasswordHasher.Hash(plainPassword)
+	if err == nil {
+		_, err = manager.Database.Exec("UPDATE Users SET password = $1 WHERE ID = $2 AND password = $3",
+			newHash, id, oldHash)
+	}
+	if err != nil {
+		log.Println("manager.rehashPassword error: " + err.Error())
+	}
+}
+
+// Get returns an user model by email
+func (manager *Manager) Get(email string) (*Model, error) {
+	model := New(email)
+	row := manager.Database.QueryRow("SELECT ID, email_verified, totp_enabled, role FROM Users WHERE email = $1", email)
+	if err := row.Scan(&model.ID, &model.EmailVerified, &model.TOTPEnabled, &model.Role); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoUser
+		}
+		log.Println("manager.Get error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return model, nil
+}
+
+// ResolveClaims fills in the user id of legacy claims which identify the user by email only
+func (manager *Manager) ResolveClaims(claims *userauth.Claims) error {
+	if !claims.IsLegacy() {
+		return nil
+	}
+	var id int
+	if err := manager.Database.QueryRow("SELECT ID FROM Users WHERE email = $1", claims.Email).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoUser
+		}
+		log.Println("manager.ResolveClaims error: " + err.Error())
+		return ErrInternal
+	}
+	claims.SetUserID(id)
+	return nil
 }
 
 // ChangePassword changes an user password
-func (manager *Manager) ChangePassword(email, oldPassword, newPassword string) error {
-	var hashedPassword string
-	row := manager.Database.QueryRow("SELECT password FROM Users WHERE email = $1", email)
-	if err := row.Scan(&hashedPassword); err != nil {
+func (manager *Manager) ChangePassword(id int, oldPassword, newPassword string) error {
+	return manager.changePassword(manager.Database, id, oldPassword, newPassword)
+}
+
+func (manager *Manager) changePassword(db querier, id int, oldPassword, newPassword string) error {
+	var email, hashedPassword string
+	row := db.QueryRow("SELECT email, password FROM Users WHERE ID = $1", id)
+	if err := row.Scan(&email, &hashedPassword); err != nil {
 		log.Println("manager.ChangePassword error: " + err.Error())
 		if err == sql.ErrNoRows {
 			return ErrNoUser
 		}
 		return ErrInternal
 	}
-	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(oldPassword)) != nil {
+	if matched, _, _ := manager.PasswordHasher.Verify(oldPassword, hashedPassword); !matched {
 		return ErrNotMatched
 	}
-	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err := manager.PasswordPolicy.Validate("new_password", email, newPassword); err != nil {
+		return err
+	}
+	newHashedPassword, err := manager.PasswordHasher.Hash(newPassword)
 	if err != nil {
+		log.Println("manager.ChangePassword error: " + err.Error())
 		return ErrInternal
 	}
-	_, err = manager.Database.Exec("UPDATE Users SET password = $1 WHERE EMAIL = $2", newHashedPassword, email)
+	// tokens issued before the change stop being accepted
+	_, err = db.Exec("UPDATE Users SET password = $1, password_reset_required = FALSE, tokens_valid_after = NOW() "+
+		"WHERE ID = $2", newHashedPassword, id)
 	if err != nil {
 		log.Println("manager.ChangePassword error: " + err.Error())
 		return ErrInternal
@@ -90,11 +175,45 @@ func (manager *Manager) IsEmailRegistered(email string) bool {
 	return registered
 }
 
-// GetModelFromToken returns an user model based on a JWT token
-func (manager *Manager) GetModelFromToken(token string, secret []byte) (*Model, error) {
-	claims, err := userauth.GetClaims(token, secret)
+// GetModelFromToken returns an user model based on a JWT token which hasn't been revoked,
+// impersonation tokens are rejected with userauth.ErrImpersonated
+func (manager *Manager) GetModelFromToken(token string, keys *userauth.KeyRing) (*Model, error) {
+	claims, err := userauth.GetClaims(token, keys)
 	if err != nil {
 		return nil, ErrInvalidToken
 	}
-	return New(claims.Email), nil
+	if err = manager.ValidateToken(claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.IsImpersonated() {
+		return nil, userauth.ErrImpersonated
+	}
+	return FromClaims(claims), nil
+}
+
+// VerifyEmail marks an user email address as confirmed
+func (manager *Manager) VerifyEmail(id int) error {
+	result, err := manager.Database.Exec("UPDATE Users SET email_verified = TRUE WHERE ID = $1", id)
+	if err != nil {
+		log.Println("manager.VerifyEmail error: " + err.Error())
+		return ErrInternal
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrNoUser
+	}
+	return nil
+}
+
+// IsEmailVerified checks whether an user has confirmed the email address
+func (manager *Manager) IsEmailVerified(id int) (bool, error) {
+	var verified bool
+	row := manager.Database.QueryRow("SELECT email_verified FROM Users WHERE ID = $1", id)
+	if err := row.Scan(&verified); err != nil {
+		if err == sql.ErrNoRows {
+			return false, ErrNoUser
+		}
+		log.Println("manager.IsEmailVerified error: " + err.Error())
+		return false, ErrInternal
+	}
+	return verified, nil
 }
@@ -1,23 +1,155 @@
 package user
 
-import "github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+import (
+	"strconv"
+	"time"
 
-// Model is an user data representation
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+)
+
+const (
+	verificationTokenLifespan = time.Hour * 24
+	mfaTokenLifespan          = time.Minute * 5
+	unlockTokenLifespan       = time.Hour
+	magicLinkLifespan         = time.Minute * 15
+	emailChangeLifespan       = time.Hour * 24
+)
+
+// Model is an user data representation, profile fields are filled only by profile getters
 type Model struct {
-	Email string
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	TOTPEnabled   bool   `json:"totp_enabled"`
+	Role          string `json:"role"`
+	DisplayName   string `json:"display_name"`
+	// Avatar is a name of the stored avatar which changes with every upload, empty if there's no avatar
+	Avatar      string     `json:"-"`
+	Locale      string     `json:"locale"`
+	TimeZone    string     `json:"time_zone"`
+	ExamYear    *int       `json:"exam_year"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+// PublicProfile is a part of an user profile visible to everyone
+type PublicProfile struct {
+	ID          int    `json:"id"`
+	DisplayName string `json:"display_name"`
+	ExamYear    *int   `json:"exam_year"`
+}
+
+// Public returns the public part of the profile
+func (model *Model) Public() *PublicProfile {
+	return &PublicProfile{
+		ID:          model.ID,
+		DisplayName: model.Name(),
+		ExamYear:    model.ExamYear,
+	}
+}
+
+// Name returns a display name, users who haven't chosen one are named by id so emails aren't exposed
+func (model *Model) Name() string {
+	if model.DisplayName != "" {
+		return model.DisplayName
+	}
+	return "User #" + strconv.Itoa(model.ID)
 }
 
 // New returns an user model
 func New(email string) *Model {
 	return &Model{
 		Email: email,
+		Role:  userauth.RoleStudent,
+	}
+}
+
+// FromClaims returns a model of an user claims were issued for
+func FromClaims(claims *userauth.Claims) *Model {
+	return &Model{
+		ID:            claims.UserID,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Role:          claims.Role,
+	}
+}
+
+// GetAuthToken returns a JWT token for authentication issued for a given session
+func (model *Model) GetAuthToken(keys *userauth.KeyRing, sessionID string) (string, error) {
+	claims := userauth.GenerateClaims(model.ID, model.Email)
+	claims.EmailVerified = model.EmailVerified
+	claims.SessionID = sessionID
+	claims.Role = model.Role
+	token, err := userauth.GenerateToken(claims, keys)
+	if err != nil {
+		return "", ErrInternal
+	}
+	return token, nil
+}
+
+// GetImpersonationToken returns a JWT token an administrator acts as the user with. It has no session,
+// so it can't be refreshed and expires along with access tokens
+func (model *Model) GetImpersonationToken(keys *userauth.KeyRing, impersonatorID int) (string, *userauth.Claims, error) {
+	claims := userauth.GenerateClaims(model.ID, model.Email)
+	claims.EmailVerified = model.EmailVerified
+	claims.Role = model.Role
+	claims.ImpersonatorID = impersonatorID
+	token, err := userauth.GenerateToken(claims, keys)
+	if err != nil {
+		return "", nil, ErrInternal
 	}
+	return token, claims, nil
+}
+
+// GetVerificationToken returns a JWT token which confirms an user email address
+func (model *Model) GetVerificationToken(keys *userauth.KeyRing) (string, error) {
+	claims := userauth.GenerateActionClaims(model.ID, model.Email, userauth.VerifyEmailPurpose, verificationTokenLifespan)
+	token, err := userauth.GenerateToken(claims, keys)
+	if err != nil {
+		return "", ErrInternal
+	}
+	return token, nil
+}
+
+// GetMFAToken returns a JWT token which proves that an user has entered a valid password,
+// it must be exchanged for an auth token along with a two-factor code
+func (model *Model) GetMFAToken(keys *userauth.KeyRing) (string, error) {
+	claims := userauth.GenerateActionClaims(model.ID, model.Email, userauth.MFAPurpose, mfaTokenLifespan)
+	token, err := userauth.GenerateToken(claims, keys)
+	if err != nil {
+		return "", ErrInternal
+	}
+	return token, nil
+}
+
+// GetUnlockToken returns a JWT token which unlocks an account locked after failed login attempts
+func (model *Model) GetUnlockToken(keys *userauth.KeyRing) (string, error) {
+	claims := userauth.GenerateActionClaims(model.ID, model.Email, userauth.UnlockPurpose, unlockTokenLifespan)
+	token, err := userauth.GenerateToken(claims, keys)
+	if err != nil {
+		return "", ErrInternal
+	}
+	return token, nil
+}
+
+// GetMagicLinkToken returns a JWT token which logs an user in without a password, its id must be
+// the id of a link created with Manager.CreateMagicLink so it can be used only once
+func (model *Model) GetMagicLinkToken(keys *userauth.KeyRing, linkID string) (string, error) {
+	claims := userauth.GenerateActionClaims(model.ID, model.Email, userauth.MagicLinkPurpose, magicLinkLifespan)
+	claims.Id = linkID
+	token, err := userauth.GenerateToken(claims, keys)
+	if err != nil {
+		return "", ErrInternal
+	}
+	return token, nil
 }
 
-// GetAuthToken returns a JWT token for authentication
-func (model *Model) GetAuthToken(secret []byte) (string, error) {
-	claims := userauth.GenerateClaims(model.Email)
-	token, err := userauth.GenerateToken(claims, secret)
+// GetEmailChangeToken returns a JWT token which confirms or cancels, depending on a given purpose,
+// an email change created with Manager.RequestEmailChange
+func (model *Model) GetEmailChangeToken(keys *userauth.KeyRing, purpose, changeID string) (string, error) {
+	claims := userauth.GenerateActionClaims(model.ID, model.Email, purpose, emailChangeLifespan)
+	claims.Id = changeID
+	token, err := userauth.GenerateToken(claims, keys)
 	if err != nil {
 		return "", ErrInternal
 	}
@@ -0,0 +1,131 @@
+// Package password hashes passwords into PHC strings with argon2id and verifies them along with legacy bcrypt hashes
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/adjsky/fetchapp_server/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	argon2idID = "argon2id"
+	saltLength = 16
+	keyLength  = 32
+)
+
+// ErrUnknownFormat is returned for stored values which aren't hashes of a supported algorithm
+var ErrUnknownFormat = errors.New("unknown password hash format")
+
+// Params are argon2id cost parameters, memory is measured in KiB
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultParams follow the second recommended option of RFC 9106 scaled down for a small server
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+}
+
+// Hasher hashes passwords with given parameters
+type Hasher struct {
+	Params Params
+}
+
+// New returns a hasher using given parameters
+func New(params Params) *Hasher {
+	return &Hasher{
+		Params: params,
+	}
+}
+
+// Load returns a hasher with parameters from the configuration
+func Load(data *config.PasswordHashData) *Hasher {
+	return New(Params{
+		Memory:      data.Memory,
+		Iterations:  data.Iterations,
+		Parallelism: data.Parallelism,
+	})
+}
+
+// Hash returns a PHC string like $argon2id$v=19$m=65536,t=3,p=2$salt$hash. A nil hasher uses DefaultParams
+func (hasher *Hasher) Hash(password string) (string, error) {
+	params := hasher.params()
+	salt := make([]byte, saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, keyLength)
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2idID, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks a password against a stored hash and reports whether the hash should be replaced
+// with a new one because it uses a legacy algorithm or weaker parameters than the hasher
+func (hasher *Hasher) Verify(password, hash string) (bool, bool, error) {
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, false, nil
+		}
+		return err == nil, true, err
+	}
+	params, salt, key, err := decode(hash)
+	if err != nil {
+		return false, false, err
+	}
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, computed) != 1 {
+		return false, false, nil
+	}
+	current := hasher.params()
+	weaker := params.Memory < current.Memory || params.Iterations < current.Iterations ||
+		params.Parallelism < current.Parallelism || len(key) < keyLength
+	return true, weaker, nil
+}
+
+func (hasher *Hasher) params() Params {
+	if hasher == nil {
+		return DefaultParams
+	}
+	return hasher.Params
+}
+
+// decode parses an argon2id PHC string
+func decode(hash string) (Params, []byte, []byte, error) {
+	var (
+		params  Params
+		version int
+	)
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != argon2idID {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	return params, salt, key, nil
+}
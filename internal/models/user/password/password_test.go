@@ -0,0 +1,67 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep tests fast, they aren't meant for production
+var testParams = Params{Memory: 1024, Iterations: 2, Parallelism: 1}
+
+func TestHashVerify(t *testing.T) {
+	hasher := New(testParams)
+	hash, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=2,p=1$") {
+		t.Errorf("unexpected hash format: %v", hash)
+	}
+	t.Run("Matching password is accepted without a rehash",
+		func(t *testing.T) {
+			matched, rehash, err := hasher.Verify("correct horse", hash)
+			if err != nil || !matched || rehash {
+				t.Errorf("expected a match without a rehash, got: %v, %v, %v", matched, rehash, err)
+			}
+		})
+	t.Run("Wrong password is rejected",
+		func(t *testing.T) {
+			if matched, _, err := hasher.Verify("battery staple", hash); err != nil || matched {
+				t.Errorf("expected a mismatch, got: %v, %v", matched, err)
+			}
+		})
+	t.Run("Hash with weaker parameters needs a rehash",
+		func(t *testing.T) {
+			stronger := New(Params{Memory: 2048, Iterations: 2, Parallelism: 1})
+			matched, rehash, err := stronger.Verify("correct horse", hash)
+			if err != nil || !matched || !rehash {
+				t.Errorf("expected a match with a rehash, got: %v, %v, %v", matched, rehash, err)
+			}
+		})
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	hasher := New(testParams)
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	t.Run("Bcrypt hash is verified and needs a rehash",
+		func(t *testing.T) {
+			matched, rehash, err := hasher.Verify("correct horse", string(legacy))
+			if err != nil || !matched || !rehash {
+				t.Errorf("expected a match with a rehash, got: %v, %v, %v", matched, rehash, err)
+			}
+		})
+	t.Run("Wrong password doesn't match a bcrypt hash",
+		func(t *testing.T) {
+			if matched, _, err := hasher.Verify("battery staple", string(legacy)); err != nil || matched {
+				t.Errorf("expected a mismatch, got: %v, %v", matched, err)
+			}
+		})
+	t.Run("Unknown format never matches",
+		func(t *testing.T) {
+			if matched, _, err := hasher.Verify("!", "!"); err != ErrUnknownFormat || matched {
+				t.Errorf("expected an unknown format error, got: %v, %v", matched, err)
+			}
+		})
+}
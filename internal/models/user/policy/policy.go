@@ -0,0 +1,239 @@
+// Package policy validates passwords against configurable rules
+package policy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adjsky/fetchapp_server/config"
+)
+
+// FieldError describes why a field value has been rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when a password violates the policy
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (err *ValidationError) Error() string {
+	return "the password doesn't satisfy the password policy"
+}
+
+// Violation is a result of a failed rule check
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Rule checks a password, the email of its owner is passed for rules comparing them
+type Rule interface {
+	Check(email, password string) *Violation
+}
+
+// Policy is a set of rules every password must follow
+type Policy struct {
+	Rules []Rule
+}
+
+// New returns a policy consisting of given rules
+func New(rules ...Rule) *Policy {
+	return &Policy{
+		Rules: rules,
+	}
+}
+
+// Load builds a policy from the configuration, the breached password list is read into memory
+func Load(data *config.PasswordPolicyData) (*Policy, error) {
+	rules := []Rule{
+		MinLength(data.MinLength),
+		MaxLength(data.MaxLength),
+		NotEmail(),
+	}
+	if data.CharacterClasses > 0 {
+		rules = append(rules, CharacterClasses(data.CharacterClasses))
+	}
+	if data.BreachedListPath != "" {
+		breached, err := LoadBreachedList(data.BreachedListPath)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, breached)
+	}
+	return New(rules...), nil
+}
+
+// Validate checks a password against every rule, field names the request field the password came from
+func (policy *Policy) Validate(field, email, password string) error {
+	if policy == nil {
+		return nil
+	}
+	var errors []FieldError
+	for _, rule := range policy.Rules {
+		if violation := rule.Check(email, password); violation != nil {
+			errors = append(errors, FieldError{
+				Field:   field,
+				Rule:    violation.Rule,
+				Message: violation.Message,
+			})
+		}
+	}
+	if len(errors) == 0 {
+		return nil
+	}
+	return &ValidationError{
+		Errors: errors,
+	}
+}
+
+// RuleFunc adapts an ordinary function to the Rule interface
+type RuleFunc func(email, password string) *Violation
+
+// Check calls the function
+func (f RuleFunc) Check(email, password string) *Violation {
+	return f(email, password)
+}
+
+// MinLength requires a password to have at least a given number of characters
+func MinLength(length int) Rule {
+	return RuleFunc(func(_, password string) *Violation {
+		if utf8.RuneCountInString(password) < length {
+			return &Violation{
+				Rule:    "min_length",
+				Message: fmt.Sprintf("must be at least %d characters long", length),
+			}
+		}
+		return nil
+	})
+}
+
+// MaxLength limits a password length in bytes, since longer passwords can't be hashed without losing data
+func MaxLength(length int) Rule {
+	return RuleFunc(func(_, password string) *Violation {
+		if len(password) > length {
+			return &Violation{
+				Rule:    "max_length",
+				Message: fmt.Sprintf("must be at most %d bytes long", length),
+			}
+		}
+		return nil
+	})
+}
+
+// NotEmail forbids using the email address or its local part as a password
+func NotEmail() Rule {
+	return RuleFunc(func(email, password string) *Violation {
+		lowered := strings.ToLower(password)
+		email = strings.ToLower(email)
+		localPart := email
+		if at := strings.LastIndex(email, "@"); at > 0 {
+			localPart = email[:at]
+		}
+		if email != "" && (lowered == email || lowered == localPart) {
+			return &Violation{
+				Rule:    "not_email",
+				Message: "must not be the same as the email address",
+			}
+		}
+		return nil
+	})
+}
+
+// CharacterClasses requires a password to contain characters of at least a given number of classes:
+// lowercase letters, uppercase letters, digits and other symbols
+func CharacterClasses(count int) Rule {
+	return RuleFunc(func(_, password string) *Violation {
+		var lower, upper, digit, other bool
+		for _, r := range password {
+			switch {
+			case unicode.IsLower(r):
+				lower = true
+			case unicode.IsUpper(r):
+				upper = true
+			case unicode.IsDigit(r):
+				digit = true
+			default:
+				other = true
+			}
+		}
+		present := 0
+		for _, ok := range []bool{lower, upper, digit, other} {
+			if ok {
+				present++
+			}
+		}
+		if present < count {
+			return &Violation{
+				Rule: "character_classes",
+				Message: fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, symbols",
+					count),
+			}
+		}
+		return nil
+	})
+}
+
+// BreachedList rejects passwords known from data breaches, entries are kept as SHA-1 hashes
+type BreachedList map[string]struct{}
+
+// LoadBreachedList reads a file with one password per line. Lines can also be hex encoded SHA-1 hashes
+// optionally followed by a colon and a count, which is the format of the Pwned Passwords dump
+func LoadBreachedList(path string) (BreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	list := make(BreachedList)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		list[normalizeEntry(line)] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Check implements the Rule interface
+func (list BreachedList) Check(_, password string) *Violation {
+	if _, ok := list[hashPassword(password)]; ok {
+		return &Violation{
+			Rule:    "breached",
+			Message: "has appeared in a data breach, choose another one",
+		}
+	}
+	return nil
+}
+
+func normalizeEntry(line string) string {
+	hash := line
+	if colon := strings.IndexByte(line, ':'); colon == sha1.Size*2 {
+		hash = line[:colon]
+	}
+	if len(hash) == sha1.Size*2 {
+		if _, err := hex.DecodeString(hash); err == nil {
+			return strings.ToUpper(hash)
+		}
+	}
+	return hashPassword(line)
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,68 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	passwordPolicy := New(MinLength(8), MaxLength(72), NotEmail(), CharacterClasses(3))
+	t.Run("A strong password passes validation",
+		func(t *testing.T) {
+			if err := passwordPolicy.Validate("password", "user@mail.ru", "Str0ng-password"); err != nil {
+				t.Error("Validate returns an error:", err)
+			}
+		})
+	t.Run("Every violated rule is reported",
+		func(t *testing.T) {
+			err := passwordPolicy.Validate("new_password", "user@mail.ru", "user")
+			validationErr, ok := err.(*ValidationError)
+			if !ok {
+				t.Fatalf("expected *ValidationError, got: %v", err)
+			}
+			rules := make(map[string]bool)
+			for _, fieldErr := range validationErr.Errors {
+				if fieldErr.Field != "new_password" {
+					t.Errorf("got field: %s, expected: new_password", fieldErr.Field)
+				}
+				rules[fieldErr.Rule] = true
+			}
+			for _, rule := range []string{"min_length", "not_email", "character_classes"} {
+				if !rules[rule] {
+					t.Errorf("the %s rule is not reported", rule)
+				}
+			}
+		})
+	t.Run("A password longer than the limit is rejected",
+		func(t *testing.T) {
+			long := make([]byte, 73)
+			for i := range long {
+				long[i] = 'a'
+			}
+			if err := New(MaxLength(72)).Validate("password", "", string(long)); err == nil {
+				t.Error("a too long password should be rejected")
+			}
+		})
+}
+
+func TestBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// the second line is SHA-1 of "password" in the Pwned Passwords format
+	content := "qwerty123\n5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3861493\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatal("LoadBreachedList returns an error:", err)
+	}
+	for _, password := range []string{"qwerty123", "password"} {
+		if list.Check("", password) == nil {
+			t.Errorf("%s should be reported as breached", password)
+		}
+	}
+	if list.Check("", "Str0ng-password") != nil {
+		t.Error("a password missing from the list is reported as breached")
+	}
+}
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adjsky/fetchapp_server/internal/emails"
+	"github.com/dchest/uniuri"
+
+	// time zones are validated against the embedded database since hosts may lack one
+	_ "time/tzdata"
+)
+
+const (
+	maxDisplayNameLength = 50
+	// examYearsAhead is how many years ahead an exam can be planned
+	examYearsAhead = 10
+	// avatarNameLength is a length of a random avatar name busting caches
+	avatarNameLength = 32
+)
+
+const profileColumns = "ID, email, email_verified, totp_enabled, role, display_name, " +
+	"COALESCE((SELECT name FROM Avatars WHERE user_id = Users.ID), ''), locale, time_zone, " +
+	"exam_year, created_at, last_login_at"
+
+// ProfileUpdate holds profile fields to change, nil fields are left as they are
+type ProfileUpdate struct {
+	// DisplayName is trimmed, an empty one clears the name
+	DisplayName *string
+	Locale      *string
+	TimeZone    *string
+	// ExamYear clears the year if it's zero
+	ExamYear *int
+}
+
+// Validate checks the changed fields and normalizes the display name
+func (update *ProfileUpdate) Validate() error {
+	if update.DisplayName != nil {
+		name := strings.TrimSpace(*update.DisplayName)
+		if utf8.RuneCountInString(name) > maxDisplayNameLength || strings.IndexFunc(name, isNotPrintable) != -1 {
+			return ErrInvalidDisplayName
+		}
+		update.DisplayName = &name
+	}
+	if update.Locale != nil && !isSupportedLocale(*update.Locale) {
+		return ErrInvalidLocale
+	}
+	if update.TimeZone != nil {
+		if _, err := time.LoadLocation(*update.TimeZone); err != nil || *update.TimeZone == "" ||
+			*update.TimeZone == "Local" {
+			return ErrInvalidTimeZone
+		}
+	}
+	if update.ExamYear != nil && *update.ExamYear != 0 {
+		year := time.Now().Year()
+		if *update.ExamYear < year-1 || *update.ExamYear > year+examYearsAhead {
+			return ErrInvalidExamYear
+		}
+	}
+	return nil
+}
+
+func isNotPrintable(r rune) bool {
+	return !unicode.IsPrint(r)
+}
+
+func isSupportedLocale(locale string) bool {
+	for _, supported := range emails.Locales {
+		if locale == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProfile returns an user model with the profile by id
+func (manager *Manager) GetProfile(id int) (*Model, error) {
+	var model Model
+	row := manager.Database.QueryRow("SELECT "+profileColumns+" FROM Users WHERE ID = $1", id)
+	err := row.Scan(&model.ID, &model.Email, &model.EmailVerified, &model.TOTPEnabled, &model.Role, &model.DisplayName,
+		&model.Avatar, &model.Locale, &model.TimeZone, &model.ExamYear, &model.CreatedAt, &model.LastLoginAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoUser
+		}
+		log.Println("manager.GetProfile error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return &model, nil
+}
+
+// UpdateProfile changes profile fields of an user and returns the updated model
+func (manager *Manager) UpdateProfile(id int, update *ProfileUpdate) (*Model, error) {
+	if err := update.Validate(); err != nil {
+		return nil, err
+	}
+	result, err := manager.Database.Exec("UPDATE Users SET display_name = COALESCE($2, display_name), "+
+		"locale = COALESCE($3, locale), time_zone = COALESCE($4, time_zone), "+
+		"exam_year = CASE WHEN $5::INTEGER IS NULL THEN exam_year ELSE NULLIF($5::INTEGER, 0) END "+
+		"WHERE ID = $1", id, update.DisplayName, update.Locale, update.TimeZone, update.ExamYear)
+	if err != nil {
+		log.Println("manager.UpdateProfile error: " + err.Error())
+		return nil, ErrInternal
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return nil, ErrNoUser
+	}
+	return manager.GetProfile(id)
+}
+
+// SetAvatar stores an avatar image of an user replacing the previous one under a new name
+func (manager *Manager) SetAvatar(id int, data []byte) error {
+	result, err := manager.Database.Exec("INSERT INTO Avatars (user_id, name, data) SELECT ID, $2, $3 FROM Users "+
+		"WHERE ID = $1 ON CONFLICT (user_id) DO UPDATE SET name = EXCLUDED.name, data = EXCLUDED.data, created_at = NOW()",
+		id, uniuri.NewLen(avatarNameLength), data)
+	if err != nil {
+		log.Println("manager.SetAvatar error: " + err.Error())
+		return ErrInternal
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return ErrNoUser
+	}
+	return nil
+}
+
+// DeleteAvatar removes an avatar of an user
+func (manager *Manager) DeleteAvatar(id int) error {
+	if _, err := manager.Database.Exec("DELETE FROM Avatars WHERE user_id = $1", id); err != nil {
+		log.Println("manager.DeleteAvatar error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// GetAvatar returns an avatar image of an user
+func (manager *Manager) GetAvatar(id int) ([]byte, error) {
+	var data []byte
+	if err := manager.Database.QueryRow("SELECT data FROM Avatars WHERE user_id = $1", id).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoAvatar
+		}
+		log.Println("manager.GetAvatar error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return data, nil
+}
@@ -0,0 +1,35 @@
+package user
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfileUpdateValidate(t *testing.T) {
+	str := func(s string) *string { return &s }
+	year := func(y int) *int { return &y }
+	thisYear := time.Now().Year()
+	for _, tc := range []struct {
+		name     string
+		update   ProfileUpdate
+		expected error
+	}{
+		{"Valid fields pass", ProfileUpdate{DisplayName: str("Ivan"), Locale: str("ru"), TimeZone: str("Europe/Moscow"),
+			ExamYear: year(thisYear + 1)}, nil},
+		{"An empty display name clears it", ProfileUpdate{DisplayName: str("   ")}, nil},
+		{"A long display name is rejected", ProfileUpdate{DisplayName: str(strings.Repeat("я", 51))}, ErrInvalidDisplayName},
+		{"A display name with control characters is rejected", ProfileUpdate{DisplayName: str("a\nb")}, ErrInvalidDisplayName},
+		{"An unsupported locale is rejected", ProfileUpdate{Locale: str("de")}, ErrInvalidLocale},
+		{"An unknown time zone is rejected", ProfileUpdate{TimeZone: str("Mars/Olympus")}, ErrInvalidTimeZone},
+		{"The local time zone is rejected", ProfileUpdate{TimeZone: str("Local")}, ErrInvalidTimeZone},
+		{"A zero exam year clears it", ProfileUpdate{ExamYear: year(0)}, nil},
+		{"A distant exam year is rejected", ProfileUpdate{ExamYear: year(thisYear + 11)}, ErrInvalidExamYear},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.update.Validate(); err != tc.expected {
+				t.Errorf("expected: %v, got: %v", tc.expected, err)
+			}
+		})
+	}
+}
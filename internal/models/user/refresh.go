@@ -0,0 +1,126 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	refreshTokenLength   = 64
+	refreshTokenLifespan = time.Hour * 24 * 30
+)
+
+// issueRefreshToken starts a refresh token family of a session and returns its first token
+func issueRefreshToken(tx *sql.Tx, userID int, sessionID string) (string, error) {
+	_, err := tx.Exec("DELETE FROM RefreshTokens WHERE expires_at < NOW() AND user_id = $1", userID)
+	if err != nil {
+		log.Println("issueRefreshToken error: " + err.Error())
+		return "", ErrInternal
+	}
+	token := uniuri.NewLen(refreshTokenLength)
+	_, err = tx.Exec("INSERT INTO RefreshTokens (user_id, family, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		userID, sessionID, helpers.HashToken(token), time.Now().Add(refreshTokenLifespan))
+	if err != nil {
+		log.Println("issueRefreshToken error: " + err.Error())
+		return "", ErrInternal
+	}
+	return token, nil
+}
+
+// refreshState holds what decides whether a refresh token can be exchanged
+type refreshState struct {
+	used      bool
+	revoked   bool
+	disabled  bool
+	expiresAt time.Time
+	// sessionCreatedAt and tokensValidAfter tell whether the session started before tokens of the user got invalidated
+	sessionCreatedAt time.Time
+	tokensValidAfter *time.Time
+}
+
+// check returns an error if a token can't be exchanged at a given time, ErrRefreshReused means
+// the token has already been exchanged and has leaked
+func (state *refreshState) check(now time.Time) error {
+	if state.used {
+		return ErrRefreshReused
+	}
+	if state.revoked || state.disabled || !now.Before(state.expiresAt) {
+		return ErrInvalidRefresh
+	}
+	if state.tokensValidAfter != nil && state.sessionCreatedAt.Before(*state.tokensValidAfter) {
+		return ErrInvalidRefresh
+	}
+	return nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same family and returns an user model
+// with the id of the session the family belongs to. A token can be exchanged only once, presenting it again
+// means it has leaked so the whole family and its session get revoked
+func (manager *Manager) RotateRefreshToken(token, ip string) (*Model, string, string, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.RotateRefreshToken error: " + err.Error())
+		return nil, "", "", ErrInternal
+	}
+	defer tx.Rollback()
+	var (
+		id     int
+		userID int
+		family string
+		state  refreshState
+		model  Model
+	)
+	row := tx.QueryRow("SELECT r.ID, r.user_id, r.family, r.used, r.revoked OR s.revoked_at IS NOT NULL, r.expires_at, "+
+		"s.created_at, u.tokens_valid_after, u.email, u.email_verified, u.role, "+
+		"u.disabled_at IS NOT NULL OR u.password_reset_required "+
+		"FROM RefreshTokens r JOIN Users u ON u.ID = r.user_id JOIN Sessions s ON s.ID = r.family "+
+		"WHERE r.token_hash = $1 FOR UPDATE OF r", helpers.HashToken(token))
+	err = row.Scan(&id, &userID, &family, &state.used, &state.revoked, &state.expiresAt, &state.sessionCreatedAt,
+		&state.tokensValidAfter, &model.Email, &model.EmailVerified, &model.Role, &state.disabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", "", ErrInvalidRefresh
+		}
+		log.Println("manager.RotateRefreshToken error: " + err.Error())
+		return nil, "", "", ErrInternal
+	}
+	err = state.check(time.Now())
+	if err == ErrRefreshReused {
+		if err = revokeSession(tx, family); err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Println("manager.RotateRefreshToken error: " + err.Error())
+			return nil, "", "", ErrInternal
+		}
+		log.Println("refresh token reuse detected, revoked session of user:", model.Email)
+		return nil, "", "", ErrRefreshReused
+	}
+	if err != nil {
+		return nil, "", "", err
+	}
+	newToken := uniuri.NewLen(refreshTokenLength)
+	newExpiresAt := time.Now().Add(refreshTokenLifespan)
+	_, err = tx.Exec("UPDATE RefreshTokens SET used = TRUE WHERE ID = $1", id)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO RefreshTokens (user_id, family, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+			userID, family, helpers.HashToken(newToken), newExpiresAt)
+	}
+	if err == nil {
+		_, err = tx.Exec("UPDATE Sessions SET ip = $1, last_seen_at = NOW(), expires_at = $2 WHERE ID = $3",
+			ip, newExpiresAt, family)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.RotateRefreshToken error: " + err.Error())
+		return nil, "", "", ErrInternal
+	}
+	model.ID = userID
+	return &model, family, newToken, nil
+}
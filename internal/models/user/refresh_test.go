@@ -0,0 +1,35 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshStateCheck(t *testing.T) {
+	now := time.Now()
+	before := now.Add(-time.Hour)
+	after := now.Add(time.Hour)
+	for _, tc := range []struct {
+		name     string
+		state    refreshState
+		expected error
+	}{
+		{"fresh token", refreshState{expiresAt: after, sessionCreatedAt: before}, nil},
+		{"used token", refreshState{used: true, expiresAt: after, sessionCreatedAt: before}, ErrRefreshReused},
+		{"used token of a revoked family", refreshState{used: true, revoked: true, expiresAt: after}, ErrRefreshReused},
+		{"revoked family", refreshState{revoked: true, expiresAt: after}, ErrInvalidRefresh},
+		{"disabled user", refreshState{disabled: true, expiresAt: after}, ErrInvalidRefresh},
+		{"expired token", refreshState{expiresAt: before}, ErrInvalidRefresh},
+		{"token expiring now", refreshState{expiresAt: now}, ErrInvalidRefresh},
+		{"session started before tokens got invalidated",
+			refreshState{expiresAt: after, sessionCreatedAt: before, tokensValidAfter: &now}, ErrInvalidRefresh},
+		{"session started after tokens got invalidated",
+			refreshState{expiresAt: after, sessionCreatedAt: now, tokensValidAfter: &before}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.state.check(now); err != tc.expected {
+				t.Errorf("got: %v, expected: %v", err, tc.expected)
+			}
+		})
+	}
+}
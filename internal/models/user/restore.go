@@ -0,0 +1,144 @@
+package user
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	restoreCodeLength      = 8
+	restoreCodeLifespan    = time.Minute * 15
+	restoreCodeMaxAttempts = 5
+)
+
+// restoreCode is an active restore code of an user, only a hash of the code is stored
+type restoreCode struct {
+	hash     string
+	attempts int
+}
+
+// exhausted reports whether the code can't be guessed anymore
+func (stored *restoreCode) exhausted() bool {
+	return stored.attempts >= restoreCodeMaxAttempts
+}
+
+// matches compares a given code with the stored one in constant time
+func (stored *restoreCode) matches(code string) bool {
+	return subtle.ConstantTimeCompare([]byte(stored.hash), []byte(helpers.HashToken(code))) == 1
+}
+
+// CreateRestoreCode creates a password restore code for an user, previously issued codes stop being valid
+func (manager *Manager) CreateRestoreCode(email string) (string, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.CreateRestoreCode error: " + err.Error())
+		return "", ErrInternal
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM RestoreCodes WHERE expires_at < NOW() OR "+
+		"user_id = (SELECT ID FROM Users WHERE email = $1)", email)
+	if err != nil {
+		log.Println("manager.CreateRestoreCode error: " + err.Error())
+		return "", ErrInternal
+	}
+	code := uniuri.NewLen(restoreCodeLength)
+	result, err := tx.Exec("INSERT INTO RestoreCodes (user_id, code_hash, expires_at) "+
+		"SELECT ID, $2, NOW() + $3 * INTERVAL '1 second' FROM Users WHERE email = $1",
+		email, helpers.HashToken(code), int(restoreCodeLifespan.Seconds()))
+	if err != nil {
+		log.Println("manager.CreateRestoreCode error: " + err.Error())
+		return "", ErrInternal
+	}
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return "", ErrNoUser
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("manager.CreateRestoreCode error: " + err.Error())
+		return "", ErrInternal
+	}
+	return code, nil
+}
+
+// CheckRestoreCode checks whether a restore code is valid for an user without using it up
+func (manager *Manager) CheckRestoreCode(email, code string) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.CheckRestoreCode error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if _, _, err = matchRestoreCode(tx, email, code); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("manager.CheckRestoreCode error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// RestorePassword changes an user password if a restore code is valid, the code can't be used again afterwards
+func (manager *Manager) RestorePassword(email, code, oldPassword, newPassword string) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.RestorePassword error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	id, userID, err := matchRestoreCode(tx, email, code)
+	if err != nil {
+		return err
+	}
+	if err = manager.changePassword(tx, userID, oldPassword, newPassword); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE RestoreCodes SET used_at = NOW() WHERE ID = $1", id)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.RestorePassword error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// matchRestoreCode locks an active restore code of an user and compares it with a given one, it returns ids
+// of the code and the user. A failed comparison is counted and committed right away, so the caller must not use
+// the transaction afterwards
+func matchRestoreCode(tx *sql.Tx, email, code string) (int, int, error) {
+	var (
+		id     int
+		userID int
+		stored restoreCode
+	)
+	row := tx.QueryRow("SELECT r.ID, r.user_id, r.code_hash, r.attempts FROM RestoreCodes r JOIN Users u ON u.ID = r.user_id "+
+		"WHERE u.email = $1 AND r.used_at IS NULL AND r.expires_at > NOW() FOR UPDATE OF r", email)
+	if err := row.Scan(&id, &userID, &stored.hash, &stored.attempts); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, 0, ErrInvalidRestoreCode
+		}
+		log.Println("matchRestoreCode error: " + err.Error())
+		return 0, 0, ErrInternal
+	}
+	if stored.exhausted() {
+		return 0, 0, ErrInvalidRestoreCode
+	}
+	if !stored.matches(code) {
+		_, err := tx.Exec("UPDATE RestoreCodes SET attempts = attempts + 1 WHERE ID = $1", id)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Println("matchRestoreCode error: " + err.Error())
+			return 0, 0, ErrInternal
+		}
+		return 0, 0, ErrInvalidRestoreCode
+	}
+	return id, userID, nil
+}
@@ -0,0 +1,35 @@
+package user
+
+import (
+	"testing"
+
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+)
+
+func TestRestoreCode(t *testing.T) {
+	code := "aB3dE5fG"
+	stored := restoreCode{hash: helpers.HashToken(code)}
+	if stored.hash == code {
+		t.Fatal("the code is stored in plain text")
+	}
+	t.Run("The issued code matches", func(t *testing.T) {
+		if !stored.matches(code) {
+			t.Error("the issued code doesn't match")
+		}
+	})
+	t.Run("Another code doesn't match", func(t *testing.T) {
+		for _, other := range []string{"", "ab3de5fg", "aB3dE5fG ", stored.hash} {
+			if stored.matches(other) {
+				t.Errorf("%q matches", other)
+			}
+		}
+	})
+	t.Run("The code is exhausted after max attempts", func(t *testing.T) {
+		for attempts := 0; attempts <= restoreCodeMaxAttempts; attempts++ {
+			stored.attempts = attempts
+			if expected := attempts == restoreCodeMaxAttempts; stored.exhausted() != expected {
+				t.Errorf("attempts: %d, got exhausted: %v, expected: %v", attempts, stored.exhausted(), expected)
+			}
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+)
+
+// RevokeToken adds an access token to the revocation list until it expires
+func (manager *Manager) RevokeToken(claims *userauth.Claims) error {
+	if claims.Id == "" {
+		return nil
+	}
+	_, err := manager.Database.Exec("INSERT INTO RevokedTokens (jti, expires_at) VALUES ($1, TO_TIMESTAMP($2)) "+
+		"ON CONFLICT (jti) DO NOTHING", claims.Id, claims.ExpiresAt)
+	if err != nil {
+		log.Println("manager.RevokeToken error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// Logout revokes an access token along with the session it was issued for
+func (manager *Manager) Logout(claims *userauth.Claims) error {
+	if err := manager.RevokeToken(claims); err != nil {
+		return err
+	}
+	if claims.SessionID == "" {
+		return nil
+	}
+	if err := manager.RevokeSession(claims.UserID, claims.SessionID); err != nil && err != ErrNoSession {
+		return err
+	}
+	return nil
+}
+
+// ValidateToken rejects revoked access tokens, tokens issued before the user invalidated them, tokens of disabled
+// or deleted users, impersonation tokens of administrators who can't issue them anymore and tokens of revoked sessions,
+// the user id of legacy claims is resolved.
+// It's meant to be passed to userauth.WithValidator
+func (manager *Manager) ValidateToken(claims *userauth.Claims) error {
+	if err := manager.ResolveClaims(claims); err != nil {
+		if err == ErrNoUser {
+			return ErrTokenRevoked
+		}
+		return err
+	}
+	var state tokenState
+	row := manager.Database.QueryRow("SELECT EXISTS(SELECT 1 FROM RevokedTokens WHERE jti = $1), tokens_valid_after, "+
+		"disabled_at IS NOT NULL FROM Users WHERE ID = $2", claims.Id, claims.UserID)
+	if err := row.Scan(&state.revoked, &state.tokensValidAfter, &state.disabled); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTokenRevoked
+		}
+		log.Println("manager.ValidateToken error: " + err.Error())
+		return ErrInternal
+	}
+	if err := state.check(claims); err != nil {
+		return err
+	}
+	if claims.IsImpersonated() {
+		if err := manager.validateImpersonator(claims); err != nil {
+			return err
+		}
+	}
+	return manager.ValidateSession(claims)
+}
+
+// PurgeRevokedTokens deletes entries of the revocation list whose tokens have expired anyway
+func (manager *Manager) PurgeRevokedTokens() (int64, error) {
+	result, err := manager.Database.Exec("DELETE FROM RevokedTokens WHERE expires_at < NOW()")
+	if err != nil {
+		log.Println("manager.PurgeRevokedTokens error: " + err.Error())
+		return 0, ErrInternal
+	}
+	purged, _ := result.RowsAffected()
+	return purged, nil
+}
+
+// validateImpersonator checks that an administrator who issued an impersonation token is still an enabled administrator
+// and hasn't had tokens invalidated since then
+func (manager *Manager) validateImpersonator(claims *userauth.Claims) error {
+	var (
+		role  string
+		state tokenState
+	)
+	row := manager.Database.QueryRow("SELECT role, tokens_valid_after, disabled_at IS NOT NULL FROM Users WHERE ID = $1",
+		claims.ImpersonatorID)
+	if err := row.Scan(&role, &state.tokensValidAfter, &state.disabled); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrTokenRevoked
+		}
+		log.Println("manager.validateImpersonator error: " + err.Error())
+		return ErrInternal
+	}
+	if role != userauth.RoleAdmin || state.check(claims) != nil {
+		return ErrTokenRevoked
+	}
+	return nil
+}
+
+// tokenState holds what decides whether an access token of an user is still accepted
+type tokenState struct {
+	revoked          bool
+	disabled         bool
+	tokensValidAfter *time.Time
+}
+
+// check returns an error if a token with given claims isn't accepted anymore
+func (state *tokenState) check(claims *userauth.Claims) error {
+	if state.revoked || issuedBefore(claims, state.tokensValidAfter) {
+		return ErrTokenRevoked
+	}
+	if state.disabled {
+		return ErrAccountDisabled
+	}
+	return nil
+}
+
+// issuedBefore reports whether a token was issued before tokens of the user got invalidated,
+// a token issued at the very moment they were invalidated is still accepted
+func issuedBefore(claims *userauth.Claims, validAfter *time.Time) bool {
+	if validAfter == nil {
+		return false
+	}
+	issuedAt := time.Unix(0, claims.IssuedMicros()*int64(time.Microsecond))
+	return issuedAt.Before(*validAfter)
+}
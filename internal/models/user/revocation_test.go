@@ -0,0 +1,53 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestTokenStateCheck(t *testing.T) {
+	issuedAt := time.Date(2021, 7, 1, 12, 0, 0, 500000000, time.UTC)
+	claims := &userauth.Claims{
+		IssuedAtMicros: issuedAt.UnixNano() / int64(time.Microsecond),
+		StandardClaims: jwt.StandardClaims{IssuedAt: issuedAt.Unix()},
+	}
+	// tokens issued before the issue time in microseconds was added only have whole seconds
+	legacyClaims := &userauth.Claims{
+		StandardClaims: jwt.StandardClaims{IssuedAt: issuedAt.Unix()},
+	}
+	at := func(offset time.Duration) *time.Time {
+		validAfter := issuedAt.Add(offset)
+		return &validAfter
+	}
+	for _, tc := range []struct {
+		name     string
+		claims   *userauth.Claims
+		state    tokenState
+		expected error
+	}{
+		{"valid token", claims, tokenState{}, nil},
+		{"revoked token", claims, tokenState{revoked: true}, ErrTokenRevoked},
+		{"disabled user", claims, tokenState{disabled: true}, ErrAccountDisabled},
+		{"revoked token of a disabled user", claims, tokenState{revoked: true, disabled: true}, ErrTokenRevoked},
+		{"invalidated a second later", claims, tokenState{tokensValidAfter: at(time.Second)}, ErrTokenRevoked},
+		{"invalidated later within the same second", claims,
+			tokenState{tokensValidAfter: at(time.Millisecond * 100)}, ErrTokenRevoked},
+		{"invalidated earlier within the same second", claims,
+			tokenState{tokensValidAfter: at(-time.Millisecond * 100)}, nil},
+		{"invalidated at the issue time", claims, tokenState{tokensValidAfter: at(0)}, nil},
+		{"invalidated a second earlier", claims, tokenState{tokensValidAfter: at(-time.Second)}, nil},
+		{"legacy token invalidated within the same second", legacyClaims,
+			tokenState{tokensValidAfter: at(-time.Millisecond * 100)}, ErrTokenRevoked},
+		{"legacy token invalidated a second earlier", legacyClaims,
+			tokenState{tokensValidAfter: at(-time.Second)}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.state.check(tc.claims); err != tc.expected {
+				t.Errorf("got: %v, expected: %v", err, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	sessionIDLength = 32
+	// lastSeenPeriod limits how often the last seen time of a session is written on authenticated requests
+	lastSeenPeriod = time.Minute
+	// reauthWindow is how long after signing in an user without a password can take actions needing the password
+	reauthWindow = time.Minute * 5
+)
+
+// Session is a place an user is logged in from
+type Session struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// StartSession records a new login of an user and returns the session id with its first refresh token
+func (manager *Manager) StartSession(userID int, device, userAgent, ip string) (string, string, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.StartSession error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	defer tx.Rollback()
+	var disabled, resetRequired bool
+	row := tx.QueryRow("SELECT disabled_at IS NOT NULL, password_reset_required FROM Users WHERE ID = $1", userID)
+	if err = row.Scan(&disabled, &resetRequired); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrNoUser
+		}
+		log.Println("manager.StartSession error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	if disabled {
+		return "", "", ErrAccountDisabled
+	}
+	if resetRequired {
+		return "", "", ErrPasswordResetRequired
+	}
+	_, err = tx.Exec("DELETE FROM Sessions WHERE user_id = $1 AND (expires_at < NOW() OR revoked_at IS NOT NULL)", userID)
+	if err != nil {
+		log.Println("manager.StartSession error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	sessionID := uniuri.NewLen(sessionIDLength)
+	_, err = tx.Exec("INSERT INTO Sessions (ID, user_id, device, user_agent, ip, expires_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		sessionID, userID, truncate(device, 100), truncate(userAgent, 255), ip, time.Now().Add(refreshTokenLifespan))
+	if err != nil {
+		log.Println("manager.StartSession error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	refreshToken, err := issueRefreshToken(tx, userID, sessionID)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err = tx.Exec("UPDATE Users SET last_login_at = NOW() WHERE ID = $1", userID); err != nil {
+		log.Println("manager.StartSession error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("manager.StartSession error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	return sessionID, refreshToken, nil
+}
+
+// GetSessions returns active sessions of an user, most recently used first
+func (manager *Manager) GetSessions(userID int) ([]Session, error) {
+	rows, err := manager.Database.Query("SELECT ID, device, user_agent, ip, created_at, last_seen_at FROM Sessions "+
+		"WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW() ORDER BY last_seen_at DESC", userID)
+	if err != nil {
+		log.Println("manager.GetSessions error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer rows.Close()
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var session Session
+		err = rows.Scan(&session.ID, &session.Device, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			log.Println("manager.GetSessions error: " + err.Error())
+			return nil, ErrInternal
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("manager.GetSessions error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return sessions, nil
+}
+
+// RevokeSession logs an user out of a session, its refresh tokens stop being valid as well
+func (manager *Manager) RevokeSession(userID int, sessionID string) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.RevokeSession error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	var exists bool
+	row := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM Sessions WHERE ID = $1 AND user_id = $2 AND revoked_at IS NULL)",
+		sessionID, userID)
+	if err = row.Scan(&exists); err != nil {
+		log.Println("manager.RevokeSession error: " + err.Error())
+		return ErrInternal
+	}
+	if !exists {
+		return ErrNoSession
+	}
+	if err = revokeSession(tx, sessionID); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.RevokeSession error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// RevokeAllSessions logs an user out everywhere
+func (manager *Manager) RevokeAllSessions(userID int) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.RevokeAllSessions error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if err = revokeUserSessions(tx, userID); err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.RevokeAllSessions error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// ValidateSession checks that a session an auth token was issued for hasn't been revoked.
+// It's meant to be passed to userauth.WithValidator
+func (manager *Manager) ValidateSession(claims *userauth.Claims) error {
+	// tokens issued before sessions were introduced carry no session and expire on their own
+	if claims.SessionID == "" {
+		return nil
+	}
+	var active bool
+	row := manager.Database.QueryRow("SELECT revoked_at IS NULL AND expires_at > NOW() FROM Sessions WHERE ID = $1",
+		claims.SessionID)
+	if err := row.Scan(&active); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrSessionRevoked
+		}
+		log.Println("manager.ValidateSession error: " + err.Error())
+		return ErrInternal
+	}
+	if !active {
+		return ErrSessionRevoked
+	}
+	_, err := manager.Database.Exec("UPDATE Sessions SET last_seen_at = NOW() WHERE ID = $1 AND "+
+		"last_seen_at < NOW() - $2 * INTERVAL '1 second'", claims.SessionID, int(lastSeenPeriod.Seconds()))
+	if err != nil {
+		log.Println("manager.ValidateSession error: " + err.Error())
+	}
+	return nil
+}
+
+func revokeSession(tx *sql.Tx, sessionID string) error {
+	_, err := tx.Exec("UPDATE Sessions SET revoked_at = NOW() WHERE ID = $1 AND revoked_at IS NULL", sessionID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE RefreshTokens SET revoked = TRUE WHERE family = $1", sessionID)
+	return err
+}
+
+func revokeUserSessions(tx *sql.Tx, userID int) error {
+	_, err := tx.Exec("UPDATE Sessions SET revoked_at = NOW() WHERE revoked_at IS NULL AND user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE RefreshTokens SET revoked = TRUE WHERE user_id = $1", userID)
+	return err
+}
+
+// truncate cuts a string to a given number of characters to fit a column
+func truncate(value string, length int) string {
+	runes := []rune(value)
+	if len(runes) > length {
+		return string(runes[:length])
+	}
+	return value
+}
+
+// reauthenticate confirms an user making a request that needs the password. Accounts created through a provider
+// have no password, so they have to sign in through it again and make the request from the session it started
+func (manager *Manager) reauthenticate(userID int, sessionID, password string) error {
+	var (
+		hashedPassword   string
+		sessionCreatedAt *time.Time
+	)
+	row := manager.Database.QueryRow("SELECT u.password, s.created_at FROM Users u "+
+		"LEFT JOIN Sessions s ON s.ID = $2 AND s.user_id = u.ID AND s.revoked_at IS NULL WHERE u.ID = $1",
+		userID, sessionID)
+	if err := row.Scan(&hashedPassword, &sessionCreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoUser
+		}
+		log.Println("manager.reauthenticate error: " + err.Error())
+		return ErrInternal
+	}
+	if hashedPassword != noPassword {
+		_, err := manager.MatchPasswordByID(userID, password)
+		return err
+	}
+	if !reauthenticated(sessionCreatedAt, time.Now()) {
+		return ErrReauthRequired
+	}
+	return nil
+}
+
+// reauthenticated reports whether an active session started recently enough at a given time to count
+// as signing in again, it's nil if there's no such session
+func reauthenticated(sessionCreatedAt *time.Time, now time.Time) bool {
+	return sessionCreatedAt != nil && now.Sub(*sessionCreatedAt) < reauthWindow
+}
@@ -0,0 +1,31 @@
+package user
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReauthenticated(t *testing.T) {
+	now := time.Now()
+	at := func(offset time.Duration) *time.Time {
+		createdAt := now.Add(offset)
+		return &createdAt
+	}
+	for _, tc := range []struct {
+		name      string
+		createdAt *time.Time
+		expected  bool
+	}{
+		{"no active session", nil, false},
+		{"session started just now", at(0), true},
+		{"session started within the window", at(-reauthWindow + time.Second), true},
+		{"session started when the window closes", at(-reauthWindow), false},
+		{"session started long ago", at(-time.Hour * 24), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := reauthenticated(tc.createdAt, now); got != tc.expected {
+				t.Errorf("got: %v, expected: %v", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package user
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/adjsky/fetchapp_server/pkg/totp"
+	"github.com/dchest/uniuri"
+)
+
+const (
+	totpIssuer          = "fetchapp"
+	recoveryCodesCount  = 10
+	recoveryCodesLength = 10
+)
+
+// EnrollTOTP generates a new two-factor secret for an user, it's not required on login until confirmed
+func (manager *Manager) EnrollTOTP(id int) (secret, uri string, err error) {
+	secret, err = totp.GenerateSecret()
+	if err != nil {
+		return "", "", ErrInternal
+	}
+	var email string
+	row := manager.Database.QueryRow("UPDATE Users SET totp_secret = $1 WHERE ID = $2 AND NOT totp_enabled RETURNING email",
+		secret, id)
+	if err = row.Scan(&email); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrTOTPEnabled
+		}
+		log.Println("manager.EnrollTOTP error: " + err.Error())
+		return "", "", ErrInternal
+	}
+	return secret, totp.URI(totpIssuer, email, secret), nil
+}
+
+// ConfirmTOTP enables two-factor authentication if a code matches the enrolled secret and returns recovery codes
+func (manager *Manager) ConfirmTOTP(userID int, code string) ([]string, error) {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.ConfirmTOTP error: " + err.Error())
+		return nil, ErrInternal
+	}
+	defer tx.Rollback()
+	var (
+		secret  sql.NullString
+		enabled bool
+	)
+	row := tx.QueryRow("SELECT totp_secret, totp_enabled FROM Users WHERE ID = $1 FOR UPDATE", userID)
+	if err := row.Scan(&secret, &enabled); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNoUser
+		}
+		log.Println("manager.ConfirmTOTP error: " + err.Error())
+		return nil, ErrInternal
+	}
+	if enabled {
+		return nil, ErrTOTPEnabled
+	}
+	if !secret.Valid {
+		return nil, ErrTOTPNotEnrolled
+	}
+	step, ok := totp.Validate(secret.String, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+	_, err = tx.Exec("UPDATE Users SET totp_enabled = TRUE, totp_last_step = $1 WHERE ID = $2", step, userID)
+	if err != nil {
+		log.Println("manager.ConfirmTOTP error: " + err.Error())
+		return nil, ErrInternal
+	}
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("manager.ConfirmTOTP error: " + err.Error())
+		return nil, ErrInternal
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off if a code or a recovery code is valid
+func (manager *Manager) DisableTOTP(userID int, code string) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.DisableTOTP error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if err = matchSecondFactor(tx, userID, code); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE Users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE ID = $1", userID)
+	if err == nil {
+		_, err = tx.Exec("DELETE FROM RecoveryCodes WHERE user_id = $1", userID)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Println("manager.DisableTOTP error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// VerifySecondFactor checks a two-factor code or a recovery code of an user, both of them can be used only once
+func (manager *Manager) VerifySecondFactor(userID int, code string) error {
+	tx, err := manager.Database.Begin()
+	if err != nil {
+		log.Println("manager.VerifySecondFactor error: " + err.Error())
+		return ErrInternal
+	}
+	defer tx.Rollback()
+	if err = matchSecondFactor(tx, userID, code); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Println("manager.VerifySecondFactor error: " + err.Error())
+		return ErrInternal
+	}
+	return nil
+}
+
+// matchSecondFactor validates a code of an user with enabled two-factor authentication and marks it as used
+func matchSecondFactor(tx *sql.Tx, userID int, code string) error {
+	var (
+		secret   sql.NullString
+		enabled  bool
+		lastStep int64
+	)
+	row := tx.QueryRow("SELECT totp_secret, totp_enabled, totp_last_step FROM Users WHERE ID = $1 FOR UPDATE", userID)
+	if err := row.Scan(&secret, &enabled, &lastStep); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNoUser
+		}
+		log.Println("matchSecondFactor error: " + err.Error())
+		return ErrInternal
+	}
+	if !enabled || !secret.Valid {
+		return ErrTOTPNotEnrolled
+	}
+	// a code can't be replayed, so only steps after the last accepted one are valid
+	if step, ok := totp.Validate(secret.String, code, time.Now()); ok && step > lastStep {
+		_, err := tx.Exec("UPDATE Users SET totp_last_step = $1 WHERE ID = $2", step, userID)
+		if err != nil {
+			log.Println("matchSecondFactor error: " + err.Error())
+			return ErrInternal
+		}
+		return nil
+	}
+	result, err := tx.Exec("UPDATE RecoveryCodes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		userID, helpers.HashToken(code))
+	if err != nil {
+		log.Println("matchSecondFactor error: " + err.Error())
+		return ErrInternal
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return ErrInvalidTOTPCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodes removes recovery codes of an user and generates new ones
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM RecoveryCodes WHERE user_id = $1", userID)
+	if err != nil {
+		log.Println("replaceRecoveryCodes error: " + err.Error())
+		return nil, ErrInternal
+	}
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		codes[i] = uniuri.NewLen(recoveryCodesLength)
+		_, err = tx.Exec("INSERT INTO RecoveryCodes (user_id, code_hash) VALUES ($1, $2)", userID, helpers.HashToken(codes[i]))
+		if err != nil {
+			log.Println("replaceRecoveryCodes error: " + err.Error())
+			return nil, ErrInternal
+		}
+	}
+	return codes, nil
+}
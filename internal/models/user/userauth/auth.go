@@ -2,55 +2,145 @@ package userauth
 
 import (
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/dchest/uniuri"
 	"github.com/dgrijalva/jwt-go"
 )
 
 const (
-	tokenIssuer       = "adjsky"
-	tokenSubject      = "auth"
-	authTokenLifespan = time.Hour * 24
+	tokenIssuer   = "adjsky"
+	authPurpose   = "auth"
+	tokenIDLength = 32
+	// AuthTokenLifespan is how long an access token stays valid, clients are expected to use refresh tokens to get a new one
+	AuthTokenLifespan = time.Minute * 15
 )
 
-// Claims holds user information passed by Authorization HTTP header
+const (
+	// VerifyEmailPurpose marks tokens sent to users to confirm their email address
+	VerifyEmailPurpose = "verify_email"
+	// MFAPurpose marks tokens issued after a password check to users who have two-factor authentication enabled
+	MFAPurpose = "mfa_pending"
+	// UnlockPurpose marks tokens sent to users whose account got locked after failed login attempts
+	UnlockPurpose = "unlock"
+	// MagicLinkPurpose marks single-use tokens sent to users to log in without a password
+	MagicLinkPurpose = "magic_link"
+	// ConfirmEmailChangePurpose marks tokens sent to a new email address of an user to confirm it
+	ConfirmEmailChangePurpose = "confirm_email_change"
+	// CancelEmailChangePurpose marks tokens sent to a current email address of an user to cancel its change
+	CancelEmailChangePurpose = "cancel_email_change"
+)
+
+var (
+	// ErrInvalidClaims is returned for tokens which are signed properly but weren't issued for the requested purpose
+	ErrInvalidClaims = errors.New("token has invalid claims")
+	// ErrImpersonated is returned for impersonation tokens used for actions only the user can take
+	ErrImpersonated = errors.New("not allowed while impersonating an user")
+)
+
+// Claims holds user information passed by Authorization HTTP header. The sub claim is the user id,
+// tokens issued before that had the purpose in sub and identified users by email
 type Claims struct {
-	Email string
+	// UserID is parsed from the subject, it's zero for legacy tokens until the email is resolved to an id
+	UserID int `json:"-"`
+	// Purpose tells what a token can be used for, e.g. authentication or confirming an email address
+	Purpose       string `json:"purpose,omitempty"`
+	Email         string
+	EmailVerified bool `json:"email_verified"`
+	// SessionID references the login the token was issued for, every token also has its own id in the jti claim
+	SessionID string `json:"sid,omitempty"`
+	Role      string `json:"role,omitempty"`
+	// ImpersonatorID is an id of an administrator acting as the user, it's zero for tokens the user got by logging in
+	ImpersonatorID int `json:"imp,omitempty"`
+	// IssuedAtMicros is the issue time in unix microseconds, iat has whole seconds only so it can't tell
+	// whether a token was issued before or after tokens of the user got invalidated within the same second
+	IssuedAtMicros int64 `json:"iat_us,omitempty"`
+	// Scopes restrict what requests authenticated with an API key can access, they are never put in tokens
+	Scopes []string `json:"-"`
 	jwt.StandardClaims
 }
 
 // GenerateClaims generates a new JWT token claims
-func GenerateClaims(email string) *Claims {
-	return &Claims{
-		Email: email,
+func GenerateClaims(userID int, email string) *Claims {
+	return GenerateActionClaims(userID, email, authPurpose, AuthTokenLifespan)
+}
+
+// GenerateActionClaims generates claims for a single purpose token, such tokens can't be used for authentication
+func GenerateActionClaims(userID int, email, purpose string, lifespan time.Duration) *Claims {
+	now := time.Now()
+	claims := &Claims{
+		Purpose:        purpose,
+		Email:          email,
+		IssuedAtMicros: now.UnixNano() / int64(time.Microsecond),
 		StandardClaims: jwt.StandardClaims{
-			IssuedAt:  time.Now().Unix(),
+			Id:        uniuri.NewLen(tokenIDLength),
+			IssuedAt:  now.Unix(),
 			Issuer:    tokenIssuer,
-			Subject:   tokenSubject,
-			ExpiresAt: time.Now().Add(authTokenLifespan).Unix(),
+			ExpiresAt: now.Add(lifespan).Unix(),
 		},
 	}
+	claims.SetUserID(userID)
+	return claims
+}
+
+// SetUserID makes a given user the subject of claims
+func (claims *Claims) SetUserID(id int) {
+	claims.UserID = id
+	claims.Subject = strconv.Itoa(id)
+}
+
+// IsLegacy reports whether claims come from a token identifying the user by email only
+func (claims *Claims) IsLegacy() bool {
+	return claims.UserID == 0
+}
+
+// IssuedMicros returns the issue time in unix microseconds, tokens issued without it are taken
+// as issued at the start of the second in iat
+func (claims *Claims) IssuedMicros() int64 {
+	if claims.IssuedAtMicros != 0 {
+		return claims.IssuedAtMicros
+	}
+	return claims.IssuedAt * int64(time.Second/time.Microsecond)
+}
+
+// IsImpersonated reports whether claims were issued to an administrator acting as the user
+func (claims *Claims) IsImpersonated() bool {
+	return claims.ImpersonatorID != 0
 }
 
-// GenerateToken returns a JWT string that is passed to a client
-func GenerateToken(claims *Claims, secretKey []byte) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString(secretKey)
-	return ss, err
+// GenerateToken returns a JWT string that is passed to a client, it's signed with the current key of a key ring
+func GenerateToken(claims *Claims, keys *KeyRing) (string, error) {
+	return keys.sign(claims)
 }
 
 // GetClaims decodes a JWT string passed by a client and returns data associated with it if the token is valid
-func GetClaims(tokenString string, secretKey []byte) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
-	})
+func GetClaims(tokenString string, keys *KeyRing) (*Claims, error) {
+	return GetActionClaims(tokenString, keys, authPurpose)
+}
+
+// GetActionClaims decodes a JWT string and returns its claims if the token is valid and was issued for a given purpose.
+// Legacy tokens are accepted only if the key ring still accepts them, their user id is left zero
+func GetActionClaims(tokenString string, keys *KeyRing, purpose string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keys.verificationKey)
 	if err != nil {
 		return nil, err
 	}
-	if claims, ok := token.Claims.(*Claims); ok {
-		if claims.Issuer == tokenIssuer && claims.Subject == tokenSubject {
-			return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || claims.Issuer != tokenIssuer {
+		return nil, ErrInvalidClaims
+	}
+	if claims.Purpose == "" {
+		if claims.Subject != purpose || claims.Email == "" || !keys.acceptsLegacy(claims.IssuedAt) {
+			return nil, ErrInvalidClaims
 		}
+		claims.Purpose = purpose
+		return claims, nil
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if claims.Purpose != purpose || err != nil || id <= 0 {
+		return nil, ErrInvalidClaims
 	}
-	return nil, errors.New("token has invalid claims")
+	claims.UserID = id
+	return claims, nil
 }
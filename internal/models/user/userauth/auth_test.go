@@ -17,12 +17,12 @@ func TestGenerateTokenString(t *testing.T) {
 	t.Run("GenerateTokenString doesn't return an error with valid arguments passed to",
 		func(t *testing.T) {
 			claims := Claims{
-				"John",
-				jwt.StandardClaims{
+				Email: "John",
+				StandardClaims: jwt.StandardClaims{
 					ExpiresAt: time.Now().Add(time.Hour * 24).Unix(),
 				},
 			}
-			_, err := GenerateToken(&claims, cfg.SecretKey)
+			_, err := GenerateToken(&claims, NewKeyRing(cfg.SecretKey))
 			if err != nil {
 				t.Error("GenerateTokenString returns an error:", err)
 			}
@@ -36,25 +36,33 @@ func TestGetClaims(t *testing.T) {
 	}
 	t.Run("Invalid token passed to GetClaims returns an error and nil claims",
 		func(t *testing.T) {
-			claims, err := GetClaims("invalid token", cfg.SecretKey)
+			claims, err := GetClaims("invalid token", NewKeyRing(cfg.SecretKey))
 			if err == nil && claims != nil {
 				t.Error("GetClaims returns non-nil claims and nil error")
 			}
 		})
 	t.Run("Token generated from GenerateTokenString returns valid claims",
 		func(t *testing.T) {
-			passedClaims := GenerateClaims("asdjasjdhh@mail.ru")
-			tokenString, err := GenerateToken(passedClaims, cfg.SecretKey)
+			passedClaims := GenerateClaims(1, "asdjasjdhh@mail.ru")
+			tokenString, err := GenerateToken(passedClaims, NewKeyRing(cfg.SecretKey))
 			if err != nil {
 				t.Fatal("GenerateTokenString returns an error:", err)
 			}
-			receivedClaims, err := GetClaims(tokenString, cfg.SecretKey)
+			receivedClaims, err := GetClaims(tokenString, NewKeyRing(cfg.SecretKey))
 			if err != nil {
 				t.Fatal("GetClaims returns an error:", err)
 			}
 			if receivedClaims.Email != passedClaims.Email {
 				t.Errorf("got: %s, expected: %s", receivedClaims.Email, passedClaims.Email)
 			}
+			if receivedClaims.UserID != 1 || receivedClaims.Subject != "1" {
+				t.Errorf("got user id: %d, subject: %s, expected: 1", receivedClaims.UserID, receivedClaims.Subject)
+			}
+			if receivedClaims.IssuedMicros() != passedClaims.IssuedAtMicros ||
+				receivedClaims.IssuedMicros()/int64(time.Second/time.Microsecond) != receivedClaims.IssuedAt {
+				t.Errorf("got issue time: %d, expected: %d within %d", receivedClaims.IssuedMicros(),
+					passedClaims.IssuedAtMicros, receivedClaims.IssuedAt)
+			}
 		})
 	t.Run("An outdated token can't pass validation",
 		func(t *testing.T) {
@@ -64,10 +72,90 @@ func TestGetClaims(t *testing.T) {
 					ExpiresAt: time.Now().Unix() - 10000,
 				},
 			}
-			token, _ := GenerateToken(&outdatedClaims, cfg.SecretKey)
-			claims, err := GetClaims(token, cfg.SecretKey)
+			token, _ := GenerateToken(&outdatedClaims, NewKeyRing(cfg.SecretKey))
+			claims, err := GetClaims(token, NewKeyRing(cfg.SecretKey))
 			if claims != nil && err == nil {
 				t.Error("an outdated token should be not valid, but actually is valid")
 			}
 		})
 }
+
+func TestGetActionClaims(t *testing.T) {
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	passedClaims := GenerateActionClaims(1, "asdjasjdhh@mail.ru", VerifyEmailPurpose, time.Hour)
+	tokenString, err := GenerateToken(passedClaims, NewKeyRing(cfg.SecretKey))
+	if err != nil {
+		t.Fatal("GenerateToken returns an error:", err)
+	}
+	t.Run("Action token returns valid claims for its purpose",
+		func(t *testing.T) {
+			receivedClaims, err := GetActionClaims(tokenString, NewKeyRing(cfg.SecretKey), VerifyEmailPurpose)
+			if err != nil {
+				t.Fatal("GetActionClaims returns an error:", err)
+			}
+			if receivedClaims.Email != passedClaims.Email {
+				t.Errorf("got: %s, expected: %s", receivedClaims.Email, passedClaims.Email)
+			}
+		})
+	t.Run("Action token can't be used as an auth token",
+		func(t *testing.T) {
+			claims, err := GetClaims(tokenString, NewKeyRing(cfg.SecretKey))
+			if claims != nil && err == nil {
+				t.Error("an action token should be not valid for authentication, but actually is valid")
+			}
+		})
+}
+
+func TestLegacyClaims(t *testing.T) {
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// tokens issued before user ids had the purpose in the subject and no purpose claim
+	legacyClaims := func(issuedAt time.Time, subject string) string {
+		claims := &Claims{
+			Email: "asdjasjdhh@mail.ru",
+			StandardClaims: jwt.StandardClaims{
+				IssuedAt:  issuedAt.Unix(),
+				Issuer:    tokenIssuer,
+				Subject:   subject,
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}
+		token, err := GenerateToken(claims, NewKeyRing(cfg.SecretKey))
+		if err != nil {
+			t.Fatal("GenerateToken returns an error:", err)
+		}
+		return token
+	}
+	cutoff := time.Now()
+	ring := NewKeyRing(cfg.SecretKey)
+	ring.AcceptLegacyTokens(cutoff)
+	for _, tc := range []struct {
+		name     string
+		ring     *KeyRing
+		token    string
+		purpose  string
+		expected bool
+	}{
+		{"issued before the cutoff", ring, legacyClaims(cutoff.Add(-time.Minute), authPurpose), authPurpose, true},
+		{"issued after the cutoff", ring, legacyClaims(cutoff.Add(time.Minute), authPurpose), authPurpose, false},
+		{"not accepted at all", NewKeyRing(cfg.SecretKey), legacyClaims(cutoff.Add(-time.Minute), authPurpose),
+			authPurpose, false},
+		{"another purpose", ring, legacyClaims(cutoff.Add(-time.Minute), UnlockPurpose), authPurpose, false},
+		{"action token", ring, legacyClaims(cutoff.Add(-time.Minute), UnlockPurpose), UnlockPurpose, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := GetActionClaims(tc.token, tc.ring, tc.purpose)
+			if (err == nil) != tc.expected {
+				t.Fatalf("got error: %v, expected valid: %v", err, tc.expected)
+			}
+			if err == nil && (!claims.IsLegacy() || claims.Purpose != tc.purpose || claims.Email != "asdjasjdhh@mail.ru") {
+				t.Errorf("unexpected claims: %+v", claims)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package userauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/config"
+	"github.com/adjsky/fetchapp_server/pkg/jwk"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// ErrUnsupportedKey is returned for PEM blocks which aren't RSA or Ed25519 keys
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	// ErrUnknownKey is returned for tokens signed with a key missing from the key ring
+	ErrUnknownKey = errors.New("token is signed with an unknown key")
+)
+
+// Key is an asymmetric key identified by the thumbprint of its public part
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is nil for keys which are only used to verify tokens
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeyRing signs tokens with the current key and verifies them with any key it holds, so a new key can be
+// published before it's used and an old one keeps being accepted until tokens signed with it expire.
+// Without a signing key tokens are signed with the HS256 secret. Once there's one, the secret is accepted only
+// until the end of the transition, so services holding it can't forge tokens afterwards
+type KeyRing struct {
+	secret  []byte
+	signing *Key
+	keys    map[string]*Key
+	order   []string
+	// secretUntil is when tokens signed with the secret stop being accepted after a signing key is set
+	secretUntil time.Time
+	// legacyBefore is when tokens identifying users by email stopped being issued, zero if they aren't accepted
+	legacyBefore time.Time
+}
+
+// NewKeyRing returns a key ring which signs tokens with a given secret until a signing key is added
+func NewKeyRing(secret []byte) *KeyRing {
+	return &KeyRing{
+		secret: secret,
+		keys:   make(map[string]*Key),
+	}
+}
+
+// LoadKeyRing returns a key ring with keys read from PEM files listed in a given config
+func LoadKeyRing(secret []byte, data *config.JWTKeysData) (*KeyRing, error) {
+	ring := NewKeyRing(secret)
+	ring.AcceptLegacyTokens(data.LegacyTokensIssuedBefore)
+	ring.AcceptSecretUntil(data.HS256AcceptedUntil)
+	if data.SigningKeyFile != "" {
+		key, err := readKeyFile(data.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err = ring.SetSigningKey(key); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range data.VerificationKeyFiles {
+		key, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ring.AddKey(key)
+	}
+	return ring, nil
+}
+
+// AddKey makes tokens signed with a given key acceptable
+func (ring *KeyRing) AddKey(key *Key) {
+	if _, ok := ring.keys[key.ID]; !ok {
+		ring.order = append(ring.order, key.ID)
+	}
+	ring.keys[key.ID] = key
+}
+
+// SetSigningKey makes new tokens signed with a given key, it must have a private part
+func (ring *KeyRing) SetSigningKey(key *Key) error {
+	if key.private == nil {
+		return errors.New("signing key " + key.ID + " has no private key")
+	}
+	ring.AddKey(key)
+	ring.signing = key
+	return nil
+}
+
+// AcceptSecretUntil keeps tokens signed with the secret valid until a given time after switching to a signing key,
+// so tokens issued before the switch keep working until they expire
+func (ring *KeyRing) AcceptSecretUntil(until time.Time) {
+	ring.secretUntil = until
+}
+
+// acceptsSecret reports whether tokens signed with the secret are currently accepted
+func (ring *KeyRing) acceptsSecret() bool {
+	if len(ring.secret) == 0 {
+		return false
+	}
+	return ring.signing == nil || time.Now().Before(ring.secretUntil)
+}
+
+// AcceptLegacyTokens makes tokens identifying users by email valid if they were issued before a given time,
+// so tokens issued before the switch to user ids keep working until they expire
+func (ring *KeyRing) AcceptLegacyTokens(issuedBefore time.Time) {
+	ring.legacyBefore = issuedBefore
+}
+
+// acceptsLegacy reports whether a legacy token issued at a given unix time is accepted
+func (ring *KeyRing) acceptsLegacy(issuedAt int64) bool {
+	return !ring.legacyBefore.IsZero() && issuedAt < ring.legacyBefore.Unix()
+}
+
+// sign returns a signed JWT string with the id of the signing key in the header
+func (ring *KeyRing) sign(claims jwt.Claims) (string, error) {
+	if ring.signing == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ring.secret)
+	}
+	token := jwt.NewWithClaims(ring.signing.Method, claims)
+	token.Header["kid"] = ring.signing.ID
+	return token.SignedString(ring.signing.private)
+}
+
+// verificationKey looks up a key for jwt.Parse. The algorithm of a token must match the key so
+// a public key can never be used as an HMAC secret
+func (ring *KeyRing) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if token.Method == jwt.SigningMethodHS256 && ring.acceptsSecret() {
+			return ring.secret, nil
+		}
+		return nil, ErrUnknownKey
+	}
+	key, ok := ring.keys[kid]
+	if !ok || token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrUnknownKey
+	}
+	return key.public, nil
+}
+
+// JWKS returns public parts of every asymmetric key in the ring
+func (ring *KeyRing) JWKS() jwk.Set {
+	set := jwk.Set{Keys: make([]jwk.Key, 0, len(ring.order))}
+	for _, kid := range ring.order {
+		key := ring.keys[kid]
+		encoded, err := jwk.FromPublicKey(key.ID, key.Method.Alg(), key.public)
+		if err == nil {
+			set.Keys = append(set.Keys, encoded)
+		}
+	}
+	return set
+}
+
+// JWKSHandler serves the public keys of a key ring so other services can verify tokens on their own
+func JWKSHandler(ring *KeyRing) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, ring.JWKS())
+	}
+}
+
+// ParseKey parses a PEM encoded RSA or Ed25519 key. Private keys can sign tokens, public keys only verify them
+func ParseKey(data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var (
+		parsed interface{}
+		err    error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := &Key{}
+	switch parsedKey := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.private, key.public = jwt.SigningMethodRS256, parsedKey, &parsedKey.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.public = jwt.SigningMethodRS256, parsedKey
+	case ed25519.PrivateKey:
+		key.Method, key.private, key.public = SigningMethodEdDSA, parsedKey, parsedKey.Public()
+	case ed25519.PublicKey:
+		key.Method, key.public = SigningMethodEdDSA, parsedKey
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	encoded, err := jwk.FromPublicKey("", key.Method.Alg(), key.public)
+	if err != nil {
+		return nil, err
+	}
+	if key.ID, err = encoded.Thumbprint(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func readKeyFile(path string) (*Key, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(data)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return key, nil
+}
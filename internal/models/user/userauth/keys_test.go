@@ -0,0 +1,115 @@
+package userauth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func generateKeys(t *testing.T) (*Key, *Key) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})
+	rsaParsed, err := ParseKey(rsaPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edParsed, err := ParseKey(edPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsaParsed, edParsed
+}
+
+func TestKeyRing(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, edKey := generateKeys(t)
+
+	oldRing := NewKeyRing(secret)
+	if err := oldRing.SetSigningKey(rsaKey); err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := GenerateToken(GenerateClaims(1, "loh@mail.ru"), oldRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmacToken, _ := GenerateToken(GenerateClaims(1, "loh@mail.ru"), NewKeyRing(secret))
+
+	newRing := NewKeyRing(secret)
+	if err = newRing.SetSigningKey(edKey); err != nil {
+		t.Fatal(err)
+	}
+	newToken, err := GenerateToken(GenerateClaims(1, "loh@mail.ru"), newRing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Tokens of a rotated key are accepted while the key is in the ring",
+		func(t *testing.T) {
+			if _, err := GetClaims(oldToken, newRing); err == nil {
+				t.Error("expected a token of a missing key to be rejected")
+			}
+			newRing.AddKey(rsaKey)
+			if _, err := GetClaims(oldToken, newRing); err != nil {
+				t.Errorf("expected a token of a verification key to be accepted, got: %v", err)
+			}
+			if _, err := GetClaims(newToken, newRing); err != nil {
+				t.Errorf("expected an EdDSA token to be accepted, got: %v", err)
+			}
+		})
+	t.Run("Tokens signed with the secret are accepted only during the transition to a signing key",
+		func(t *testing.T) {
+			if _, err := GetClaims(hmacToken, NewKeyRing(secret)); err != nil {
+				t.Errorf("expected an HS256 token to be accepted without a signing key, got: %v", err)
+			}
+			if _, err := GetClaims(hmacToken, newRing); err == nil {
+				t.Error("expected an HS256 token to be rejected with a signing key")
+			}
+			newRing.AcceptSecretUntil(time.Now().Add(time.Minute))
+			if _, err := GetClaims(hmacToken, newRing); err != nil {
+				t.Errorf("expected an HS256 token to be accepted during the transition, got: %v", err)
+			}
+			newRing.AcceptSecretUntil(time.Now().Add(-time.Minute))
+			if _, err := GetClaims(hmacToken, newRing); err == nil {
+				t.Error("expected an HS256 token to be rejected after the transition")
+			}
+		})
+	t.Run("A public key can't be used as an HMAC secret",
+		func(t *testing.T) {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, GenerateClaims(1, "loh@mail.ru"))
+			token.Header["kid"] = rsaKey.ID
+			forged, _ := token.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.private.(*rsa.PrivateKey).PublicKey))
+			if _, err := GetClaims(forged, newRing); err == nil {
+				t.Error("expected a forged token to be rejected")
+			}
+		})
+	t.Run("JWKS contains every asymmetric key",
+		func(t *testing.T) {
+			set := newRing.JWKS()
+			if len(set.Keys) != 2 {
+				t.Fatalf("expected 2 keys, got: %v", len(set.Keys))
+			}
+			for _, key := range []*Key{rsaKey, edKey} {
+				if _, ok := set.Find(key.ID); !ok {
+					t.Errorf("expected key %v to be published", key.ID)
+				}
+			}
+		})
+}
@@ -10,46 +10,184 @@ import (
 const (
 	// ClaimsKey constant is used to reference claims in a request context
 	ClaimsKey = "claims"
+	// APIKeyHeader is a header personal API keys are passed in
+	APIKeyHeader = "X-API-Key"
 )
 
+// Validator performs additional checks of valid claims, e.g. looks up revoked sessions
+type Validator func(claims *Claims) error
+
+// APIKeyLookup returns claims of an user who owns a given API key or an error if the key isn't valid
+type APIKeyLookup func(key string) (*Claims, error)
+
+// FailureHook is called when the middleware rejects a request, claims are nil unless the token itself was valid
+type FailureHook func(c *gin.Context, claims *Claims, reason string)
+
+// ImpersonationHook is called after a request made by an administrator acting as an user has been handled
+type ImpersonationHook func(c *gin.Context, claims *Claims)
+
+// Option configures the auth middleware
+type Option func(options *middlewareOptions)
+
+type middlewareOptions struct {
+	validators        []Validator
+	apiKeyLookup      APIKeyLookup
+	failureHook       FailureHook
+	impersonationHook ImpersonationHook
+}
+
+// WithValidator makes the middleware reject tokens a given validator returns an error for
+func WithValidator(validator Validator) Option {
+	return func(options *middlewareOptions) {
+		options.validators = append(options.validators, validator)
+	}
+}
+
+// WithAPIKeys makes the middleware accept personal API keys passed in the APIKeyHeader header
+func WithAPIKeys(lookup APIKeyLookup) Option {
+	return func(options *middlewareOptions) {
+		options.apiKeyLookup = lookup
+	}
+}
+
+// WithFailureHook makes the middleware report rejected requests, e.g. to the audit log
+func WithFailureHook(hook FailureHook) Option {
+	return func(options *middlewareOptions) {
+		options.failureHook = hook
+	}
+}
+
+// WithImpersonationHook makes the middleware report requests made with impersonation tokens, e.g. to the audit log
+func WithImpersonationHook(hook ImpersonationHook) Option {
+	return func(options *middlewareOptions) {
+		options.impersonationHook = hook
+	}
+}
+
 // Middleware checks whether a user has JWT token
-func Middleware(secretKey []byte) gin.HandlerFunc {
+func Middleware(keys *KeyRing, opts ...Option) gin.HandlerFunc {
+	var options middlewareOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	reject := func(c *gin.Context, claims *Claims, message string) {
+		if options.failureHook != nil {
+			options.failureHook(c, claims, message)
+		}
+		code := http.StatusUnauthorized
+		c.AbortWithStatusJSON(code, gin.H{
+			"code":    code,
+			"message": message,
+		})
+	}
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" && options.apiKeyLookup != nil {
+			claims, err := options.apiKeyLookup(apiKey)
+			if err != nil {
+				reject(c, nil, err.Error())
+				return
+			}
+			c.Set(ClaimsKey, claims)
+			return
+		}
 		authHeader := c.GetHeader("Authorization")
 		authData := strings.Split(authHeader, " ")
 		if len(authData) == 0 {
-			code := http.StatusUnauthorized
-			c.AbortWithStatusJSON(code, gin.H{
-				"code":    code,
-				"message": "no authorization header provided",
-			})
+			reject(c, nil, "no authorization header provided")
 			return
 		}
 		if authData[0] != "Bearer" {
-			code := http.StatusUnauthorized
+			reject(c, nil, "wrong authorization method provided")
+			return
+		}
+		if len(authData) != 2 {
+			reject(c, nil, "no token provided")
+			return
+		}
+		claims, err := GetClaims(authData[1], keys)
+		if err != nil {
+			reject(c, nil, "invalid auth token provided")
+			return
+		}
+		for _, validator := range options.validators {
+			if err = validator(claims); err != nil {
+				reject(c, claims, err.Error())
+				return
+			}
+		}
+		c.Set(ClaimsKey, claims)
+		if claims.IsImpersonated() && options.impersonationHook != nil {
+			c.Next()
+			options.impersonationHook(c, claims)
+		}
+	}
+}
+
+// RequireVerifiedEmail rejects users who haven't confirmed their email address, must be used after Middleware
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get(ClaimsKey)
+		userClaims, ok := claims.(*Claims)
+		if !ok || !userClaims.EmailVerified {
+			code := http.StatusForbidden
 			c.AbortWithStatusJSON(code, gin.H{
 				"code":    code,
-				"message": "wrong authorization method provided",
+				"message": "email address is not verified",
 			})
 			return
 		}
-		if len(authData) != 2 {
-			code := http.StatusUnauthorized
+	}
+}
+
+// RequireRole rejects users who have none of given roles, must be used after Middleware
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get(ClaimsKey)
+		userClaims, ok := claims.(*Claims)
+		if ok {
+			for _, role := range roles {
+				if userClaims.Role == role {
+					return
+				}
+			}
+		}
+		code := http.StatusForbidden
+		c.AbortWithStatusJSON(code, gin.H{
+			"code":    code,
+			"message": "insufficient role",
+		})
+	}
+}
+
+// ForbidImpersonation rejects requests made by an administrator acting as an user, it guards changes of credentials
+// which would outlive the impersonation. Must be used after Middleware
+func ForbidImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get(ClaimsKey)
+		userClaims, ok := claims.(*Claims)
+		if !ok || userClaims.IsImpersonated() {
+			code := http.StatusForbidden
 			c.AbortWithStatusJSON(code, gin.H{
 				"code":    code,
-				"message": "no token provided",
+				"message": ErrImpersonated.Error(),
 			})
 			return
 		}
-		claims, err := GetClaims(authData[1], secretKey)
-		if err != nil {
-			code := http.StatusUnauthorized
+	}
+}
+
+// RequireScope rejects requests authenticated with an API key which isn't granted a given scope, must be used after Middleware
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := c.Get(ClaimsKey)
+		userClaims, ok := claims.(*Claims)
+		if !ok || !userClaims.HasScope(scope) {
+			code := http.StatusForbidden
 			c.AbortWithStatusJSON(code, gin.H{
 				"code":    code,
-				"message": "invalid auth token provided",
+				"message": "the api key isn't granted the " + scope + " scope",
 			})
 			return
 		}
-		c.Set(ClaimsKey, claims)
 	}
 }
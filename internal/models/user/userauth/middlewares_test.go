@@ -1,8 +1,10 @@
 package userauth
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/adjsky/fetchapp_server/config"
@@ -15,7 +17,7 @@ func TestAuthMiddleware(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	handler := Middleware(cfg.SecretKey)
+	handler := Middleware(NewKeyRing(cfg.SecretKey))
 	t.Run("Request with a null authorization header returns 401 status code",
 		func(t *testing.T) {
 			writer := httptest.NewRecorder()
@@ -52,8 +54,8 @@ func TestAuthMiddleware(t *testing.T) {
 		})
 	t.Run("Middleware should pass a request with a valid token",
 		func(t *testing.T) {
-			claims := GenerateClaims("loh@mail.ru")
-			token, _ := GenerateToken(claims, cfg.SecretKey)
+			claims := GenerateClaims(1, "loh@mail.ru")
+			token, _ := GenerateToken(claims, NewKeyRing(cfg.SecretKey))
 			writer := httptest.NewRecorder()
 			ctx, _ := gin.CreateTestContext(writer)
 			req, _ := http.NewRequest("POST", "/asdasd", nil)
@@ -65,3 +67,194 @@ func TestAuthMiddleware(t *testing.T) {
 			}
 		})
 }
+
+func TestAuthMiddlewareValidator(t *testing.T) {
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revokedSession := "revoked"
+	handler := Middleware(NewKeyRing(cfg.SecretKey), WithValidator(func(claims *Claims) error {
+		if claims.SessionID == revokedSession {
+			return errors.New("the session has been revoked")
+		}
+		return nil
+	}))
+	for _, tc := range []struct {
+		name      string
+		sessionID string
+		expected  int
+	}{
+		{"Middleware should reject a token the validator fails", revokedSession, http.StatusUnauthorized},
+		{"Middleware should pass a token the validator accepts", "active", http.StatusOK},
+	} {
+		t.Run(tc.name,
+			func(t *testing.T) {
+				claims := GenerateClaims(1, "loh@mail.ru")
+				claims.SessionID = tc.sessionID
+				token, _ := GenerateToken(claims, NewKeyRing(cfg.SecretKey))
+				writer := httptest.NewRecorder()
+				ctx, _ := gin.CreateTestContext(writer)
+				req, _ := http.NewRequest("POST", "/asdasd", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				ctx.Request = req
+				handler(ctx)
+				if writer.Code != tc.expected {
+					t.Errorf("expected status code: %v, got: %v", tc.expected, writer.Code)
+				}
+			})
+	}
+}
+
+func TestAuthMiddlewareFailureHook(t *testing.T) {
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reasons []string
+	handler := Middleware(NewKeyRing(cfg.SecretKey), WithFailureHook(func(c *gin.Context, claims *Claims, reason string) {
+		reasons = append(reasons, reason)
+	}))
+	token, _ := GenerateToken(GenerateClaims(1, "loh@mail.ru"), NewKeyRing(cfg.SecretKey))
+	for _, header := range []string{"Basic asd", "Bearer asd", "Bearer " + token} {
+		writer := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(writer)
+		req, _ := http.NewRequest("POST", "/asdasd", nil)
+		req.Header.Set("Authorization", header)
+		ctx.Request = req
+		handler(ctx)
+	}
+	expected := []string{"wrong authorization method provided", "invalid auth token provided"}
+	if !reflect.DeepEqual(reasons, expected) {
+		t.Errorf("expected reasons: %v, got: %v", expected, reasons)
+	}
+}
+
+func TestAuthMiddlewareImpersonationHook(t *testing.T) {
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []int
+	keys := NewKeyRing(cfg.SecretKey)
+	router := gin.New()
+	router.POST("/asdasd", Middleware(keys, WithImpersonationHook(func(c *gin.Context, claims *Claims) {
+		statuses = append(statuses, c.Writer.Status())
+	})), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+	impersonated := GenerateClaims(1, "loh@mail.ru")
+	impersonated.ImpersonatorID = 2
+	for _, claims := range []*Claims{GenerateClaims(1, "loh@mail.ru"), impersonated} {
+		token, _ := GenerateToken(claims, keys)
+		req, _ := http.NewRequest("POST", "/asdasd", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	expected := []int{http.StatusCreated}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected the hook to see statuses: %v, got: %v", expected, statuses)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	handler := RequireRole(RoleTeacher, RoleAdmin)
+	for _, tc := range []struct {
+		name     string
+		role     string
+		expected int
+	}{
+		{"Request of a student returns 403 status code", RoleStudent, http.StatusForbidden},
+		{"Request of a token without a role returns 403 status code", "", http.StatusForbidden},
+		{"Middleware should pass a request of an admin", RoleAdmin, http.StatusOK},
+	} {
+		t.Run(tc.name,
+			func(t *testing.T) {
+				claims := GenerateClaims(1, "loh@mail.ru")
+				claims.Role = tc.role
+				writer := httptest.NewRecorder()
+				ctx, _ := gin.CreateTestContext(writer)
+				req, _ := http.NewRequest("POST", "/asdasd", nil)
+				ctx.Request = req
+				ctx.Set(ClaimsKey, claims)
+				handler(ctx)
+				if writer.Code != tc.expected {
+					t.Errorf("expected status code: %v, got: %v", tc.expected, writer.Code)
+				}
+			})
+	}
+}
+
+func TestForbidImpersonation(t *testing.T) {
+	handler := ForbidImpersonation()
+	for _, tc := range []struct {
+		name           string
+		impersonatorID int
+		expected       int
+	}{
+		{"Request of the user passes", 0, http.StatusOK},
+		{"Request of an impersonating administrator returns 403 status code", 2, http.StatusForbidden},
+	} {
+		t.Run(tc.name,
+			func(t *testing.T) {
+				claims := GenerateClaims(1, "loh@mail.ru")
+				claims.ImpersonatorID = tc.impersonatorID
+				writer := httptest.NewRecorder()
+				ctx, _ := gin.CreateTestContext(writer)
+				req, _ := http.NewRequest("POST", "/asdasd", nil)
+				ctx.Request = req
+				ctx.Set(ClaimsKey, claims)
+				handler(ctx)
+				if writer.Code != tc.expected {
+					t.Errorf("expected status code: %v, got: %v", tc.expected, writer.Code)
+				}
+			})
+	}
+}
+
+func TestAuthMiddlewareAPIKey(t *testing.T) {
+	cfg, err := config.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := Middleware(NewKeyRing(cfg.SecretKey), WithAPIKeys(func(key string) (*Claims, error) {
+		if key != "fa_valid" {
+			return nil, errors.New("invalid api key provided")
+		}
+		return &Claims{
+			Purpose: APIKeyPurpose,
+			Email:   "loh@mail.ru",
+			Scopes:  []string{ScopeEge},
+		}, nil
+	}))
+	for _, tc := range []struct {
+		name     string
+		key      string
+		scope    string
+		expected int
+	}{
+		{"Request with an invalid api key returns 401 status code", "fa_invalid", ScopeEge, http.StatusUnauthorized},
+		{"Request with an api key missing a scope returns 403 status code", "fa_valid", ScopeChat, http.StatusForbidden},
+		{"Middleware should pass a request with a valid api key", "fa_valid", ScopeEge, http.StatusOK},
+	} {
+		t.Run(tc.name,
+			func(t *testing.T) {
+				writer := httptest.NewRecorder()
+				ctx, _ := gin.CreateTestContext(writer)
+				req, _ := http.NewRequest("POST", "/asdasd", nil)
+				req.Header.Set(APIKeyHeader, tc.key)
+				ctx.Request = req
+				handler(ctx)
+				if !ctx.IsAborted() {
+					RequireScope(tc.scope)(ctx)
+				}
+				if writer.Code != tc.expected {
+					t.Errorf("expected status code: %v, got: %v", tc.expected, writer.Code)
+				}
+			})
+	}
+}
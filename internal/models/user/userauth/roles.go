@@ -0,0 +1,23 @@
+package userauth
+
+const (
+	// RoleStudent is given to every new user
+	RoleStudent = "student"
+	// RoleTeacher is given to users who manage groups of students
+	RoleTeacher = "teacher"
+	// RoleAdmin is given to users who manage other accounts
+	RoleAdmin = "admin"
+)
+
+// Roles lists every role an user can have
+var Roles = []string{RoleStudent, RoleTeacher, RoleAdmin}
+
+// IsValidRole checks whether a given role exists
+func IsValidRole(role string) bool {
+	for _, existing := range Roles {
+		if role == existing {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package userauth
+
+const (
+	// APIKeyPurpose marks claims of requests authenticated with a personal API key instead of a token
+	APIKeyPurpose = "api_key"
+	// ScopeEge grants an API key access to the ege service
+	ScopeEge = "ege"
+	// ScopeChat grants an API key access to the chat service
+	ScopeChat = "chat"
+)
+
+// Scopes lists every scope an API key can be restricted to
+var Scopes = []string{ScopeEge, ScopeChat}
+
+// IsValidScope checks whether a given scope exists
+func IsValidScope(scope string) bool {
+	for _, existing := range Scopes {
+		if scope == existing {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether claims grant a given scope. Tokens and API keys without scopes grant every scope
+func (claims *Claims) HasScope(scope string) bool {
+	if claims.Purpose != APIKeyPurpose || len(claims.Scopes) == 0 {
+		return true
+	}
+	for _, granted := range claims.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
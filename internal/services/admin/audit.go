@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/gin-gonic/gin"
+)
+
+func (serv *adminService) handleAudit(c *gin.Context) {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+	offset, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	entries, total, err := serv.auditManager.Query(filter, offset, limit)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":   code,
+		"events": entries,
+		"total":  total,
+	})
+}
+
+// handleAuditExport streams matching entries as JSON Lines oldest first
+func (serv *adminService) handleAuditExport(c *gin.Context) {
+	filter, ok := parseAuditFilter(c)
+	if !ok {
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	err := serv.auditManager.Export(filter, func(entry *audit.Entry) error {
+		return encoder.Encode(entry)
+	})
+	// the status is already sent, so a failed export can only be cut short
+	if err != nil {
+		log.Println("handleAuditExport error: " + err.Error())
+	}
+}
+
+// parseAuditFilter parses audit filter query parameters, it responds with 400 status code if they are invalid
+func parseAuditFilter(c *gin.Context) (*audit.Filter, bool) {
+	filter := &audit.Filter{
+		Event: c.Query("event"),
+		Email: c.Query("email"),
+		Actor: c.Query("actor"),
+		IP:    c.Query("ip"),
+	}
+	if userID := c.Query("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil || id < 1 {
+			respondInvalidQuery(c, "user_id")
+			return nil, false
+		}
+		filter.UserID = id
+	}
+	for param, value := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if raw := c.Query(param); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				respondInvalidQuery(c, param)
+				return nil, false
+			}
+			*value = parsed
+		}
+	}
+	return filter, true
+}
@@ -0,0 +1,5 @@
+package admin
+
+type roleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
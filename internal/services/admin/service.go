@@ -0,0 +1,173 @@
+package admin
+
+import (
+	"database/sql"
+	"net/http"
+	"net/mail"
+	"strconv"
+
+	"github.com/adjsky/fetchapp_server/config"
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/adjsky/fetchapp_server/internal/models/outbox"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/password"
+	"github.com/adjsky/fetchapp_server/internal/models/user/policy"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/internal/services"
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
+	"github.com/adjsky/fetchapp_server/pkg/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+	// userEventsSize is a number of recent security events shown along with an user
+	userEventsSize = 20
+)
+
+type adminService struct {
+	config        *config.Config
+	keys          *userauth.KeyRing
+	userManager   *user.Manager
+	outboxManager *outbox.Manager
+	auditManager  *audit.Manager
+	mailer        mailer.Mailer
+	mailFrom      mail.Address
+}
+
+// NewService creates the admin service, routes must be protected by the auth middleware and the admin role
+func NewService(cfg *config.Config, db *sql.DB, passwordPolicy *policy.Policy, passwordHasher *password.Hasher,
+	keys *userauth.KeyRing, sender mailer.Mailer) services.Service {
+	mailFrom, err := mail.ParseAddress(cfg.Mail.From)
+	if err != nil {
+		mailFrom = &mail.Address{Address: cfg.Mail.From}
+	}
+	return &adminService{
+		config:        cfg,
+		keys:          keys,
+		userManager:   user.NewManager(db, passwordPolicy, passwordHasher),
+		outboxManager: outbox.NewManager(db),
+		auditManager:  audit.NewManager(db),
+		mailer:        sender,
+		mailFrom:      *mailFrom,
+	}
+}
+
+// Register admin service in a provided router
+func (serv *adminService) Register(r *gin.RouterGroup) {
+	r.GET("/users", serv.handleUsers)
+	r.GET("/users/:id", middlewares.EnsureParamIsInt("id"), serv.handleUser)
+	r.PUT("/users/:id/role", middlewares.EnsureParamIsInt("id"), serv.handleSetRole)
+	r.POST("/users/:id/disable", middlewares.EnsureParamIsInt("id"), serv.handleDisable)
+	r.POST("/users/:id/enable", middlewares.EnsureParamIsInt("id"), serv.handleEnable)
+	r.POST("/users/:id/logout", middlewares.EnsureParamIsInt("id"), serv.handleForceLogout)
+	r.POST("/users/:id/reset-password", middlewares.EnsureParamIsInt("id"), serv.handleResetPassword)
+	r.POST("/users/:id/restore-email", middlewares.EnsureParamIsInt("id"), serv.handleRestoreEmail)
+	r.POST("/users/:id/impersonate", middlewares.EnsureParamIsInt("id"), serv.handleImpersonate)
+	r.GET("/emails", serv.handleEmails)
+	r.POST("/emails/:id/resend", middlewares.EnsureParamIsInt("id"), serv.handleResendEmail)
+	r.GET("/audit", serv.handleAudit)
+	r.GET("/audit/export", serv.handleAuditExport)
+}
+
+// Close does clean up actions on the service
+func (serv *adminService) Close() {
+	//
+}
+
+func (serv *adminService) handleEmails(c *gin.Context) {
+	offset, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	messages, total, err := serv.outboxManager.List(c.Query("status"), offset, limit)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == outbox.ErrInvalidStatus {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":   code,
+		"emails": messages,
+		"total":  total,
+	})
+}
+
+func (serv *adminService) handleResendEmail(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := serv.outboxManager.Resend(id); err != nil {
+		code := http.StatusInternalServerError
+		if err == outbox.ErrNoMessage {
+			code = http.StatusNotFound
+		} else if err == outbox.ErrErased {
+			code = http.StatusConflict
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// parsePage parses offset and limit query parameters, it responds with 400 status code if they are invalid
+func parsePage(c *gin.Context) (int, int, bool) {
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		respondInvalidQuery(c, "offset")
+		return 0, 0, false
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		respondInvalidQuery(c, "limit")
+		return 0, 0, false
+	}
+	return offset, limit, true
+}
+
+// respondUpdate responds with a result of an account update
+func respondUpdate(c *gin.Context, err error) {
+	if err != nil {
+		var code int
+		if err == user.ErrNoUser {
+			code = http.StatusNotFound
+		} else if err == user.ErrInvalidRole {
+			code = http.StatusBadRequest
+		} else if err == user.ErrImpersonationDenied {
+			code = http.StatusForbidden
+		} else if err == user.ErrNoPassword {
+			code = http.StatusConflict
+		} else {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func respondInvalidQuery(c *gin.Context, param string) {
+	code := http.StatusBadRequest
+	c.JSON(code, gin.H{
+		"code":    code,
+		"message": "invalid " + param + " query parameter provided",
+	})
+}
@@ -0,0 +1,213 @@
+package admin
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/emails"
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
+	"github.com/gin-gonic/gin"
+)
+
+// handleUsers lists accounts, they can be searched by an email prefix and sorted by the creation time
+func (serv *adminService) handleUsers(c *gin.Context) {
+	offset, limit, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	filter := &user.AccountFilter{
+		EmailPrefix: c.Query("email"),
+		Sort:        c.Query("sort"),
+	}
+	accounts, total, err := serv.userManager.ListAccounts(filter, offset, limit)
+	if err != nil {
+		if err == user.ErrInvalidSort {
+			respondInvalidQuery(c, "sort")
+			return
+		}
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":  code,
+		"users": accounts,
+		"total": total,
+	})
+}
+
+// handleUser shows an account along with its active sessions and recent security events
+func (serv *adminService) handleUser(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	account, err := serv.userManager.GetAccount(id)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	sessions, err := serv.userManager.GetSessions(id)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	events, err := serv.auditManager.ForUser(id, userEventsSize)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":     code,
+		"user":     account,
+		"sessions": sessions,
+		"events":   events,
+	})
+}
+
+func (serv *adminService) handleSetRole(c *gin.Context) {
+	var reqData roleRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := serv.userManager.SetRole(id, reqData.Role)
+	if err == nil {
+		serv.audit(c, audit.EventRoleChanged, id, reqData.Role)
+	}
+	respondUpdate(c, err)
+}
+
+func (serv *adminService) handleDisable(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := serv.userManager.Disable(id)
+	if err == nil {
+		serv.audit(c, audit.EventAccountDisabled, id, "")
+	}
+	respondUpdate(c, err)
+}
+
+func (serv *adminService) handleEnable(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := serv.userManager.Enable(id)
+	if err == nil {
+		serv.audit(c, audit.EventAccountEnabled, id, "")
+	}
+	respondUpdate(c, err)
+}
+
+// handleForceLogout revokes all sessions and access tokens of an user, including impersonation tokens
+func (serv *adminService) handleForceLogout(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := serv.userManager.ForceLogout(id)
+	if err == nil {
+		serv.audit(c, audit.EventForcedLogout, id, "")
+	}
+	respondUpdate(c, err)
+}
+
+func (serv *adminService) handleResetPassword(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := serv.userManager.RequirePasswordReset(id)
+	if err == nil {
+		serv.audit(c, audit.EventPasswordResetRequired, id, "")
+	}
+	respondUpdate(c, err)
+}
+
+// handleRestoreEmail sends an user a password restore code the same way as requested by the user
+func (serv *adminService) handleRestoreEmail(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	model, err := serv.userManager.GetProfile(id)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	restoreCode, err := serv.userManager.CreateRestoreCode(model.Email)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	serv.audit(c, audit.EventRestoreRequested, id, "")
+	serv.sendMail(model, emails.RestoreCode, emails.Data{
+		Email: model.Email,
+		Code:  restoreCode,
+	})
+	code := http.StatusAccepted
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// handleImpersonate issues an access token an administrator can act as an user with,
+// its id is recorded in the audit log so requests made with it can be traced
+func (serv *adminService) handleImpersonate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	model, err := serv.userManager.GetImpersonated(id)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	token, claims, err := model.GetImpersonationToken(serv.keys, adminClaims(c).UserID)
+	if err != nil {
+		respondUpdate(c, err)
+		return
+	}
+	serv.audit(c, audit.EventImpersonated, id, "token "+claims.Id)
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":       code,
+		"token":      token,
+		"expires_at": time.Unix(claims.ExpiresAt, 0),
+	})
+}
+
+// adminClaims returns claims of the administrator making a request
+func adminClaims(c *gin.Context) *userauth.Claims {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	return userClaims
+}
+
+// audit records an action of the administrator on an account
+func (serv *adminService) audit(c *gin.Context, event string, userID int, details string) {
+	entry := audit.NewEntry(c, event, "")
+	entry.UserID = &userID
+	entry.Actor = adminClaims(c).Email
+	entry.Details = details
+	serv.auditManager.Record(entry)
+}
+
+// sendMail sends an email to an user in the locale chosen in the profile
+func (serv *adminService) sendMail(model *user.Model, template string, data emails.Data) {
+	locale := model.Locale
+	if locale == "" {
+		locale = emails.DefaultLocale
+	}
+	subject, text, html, err := emails.Render(locale, template, data)
+	if err != nil {
+		log.Println("sendMail error: " + err.Error())
+		return
+	}
+	message := &mailer.Message{
+		From:      serv.mailFrom,
+		To:        []mail.Address{{Address: model.Email}},
+		Subject:   subject,
+		Text:      text,
+		HTML:      html,
+		Sensitive: data.Code != "" || data.Link != "",
+	}
+	if err := serv.mailer.Send(message); err != nil {
+		log.Println("sendMail error: " + err.Error())
+	}
+}
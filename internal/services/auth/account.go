@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// purgePeriod is how often accounts whose deletion grace period is over and expired revoked tokens are looked for
+const purgePeriod = time.Hour
+
+func (serv *authService) handleDeleteAccount(c *gin.Context) {
+	var reqData deleteAccountRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	deletionAt, err := serv.userManager.ScheduleDeletion(userClaims.UserID, userClaims.SessionID, reqData.Password, serv.config.AccountDeletionGracePeriod)
+	if err != nil {
+		code := http.StatusUnauthorized
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusAccepted
+	c.JSON(code, gin.H{
+		"code":        code,
+		"deletion_at": deletionAt,
+	})
+}
+
+func (serv *authService) handleCancelDeletion(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	if err := serv.userManager.CancelDeletion(userClaims.UserID); err != nil {
+		code := http.StatusConflict
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// handleExport responds with personal data of an user as JSON or as a ZIP archive with a file per section
+// if the format query parameter is "zip"
+func (serv *authService) handleExport(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	export, err := serv.userManager.Export(userClaims.UserID)
+	if err != nil {
+		code := http.StatusNotFound
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("format") != "zip" {
+		code := http.StatusOK
+		c.JSON(code, gin.H{
+			"code": code,
+			"data": export,
+		})
+		return
+	}
+	archive, err := zipExport(export)
+	if err != nil {
+		log.Println("handleExport error: " + err.Error())
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": user.ErrInternal.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="export.zip"`)
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// zipExport packs every section of an export into its own JSON file
+func zipExport(export user.Export) ([]byte, error) {
+	sections := make([]string, 0, len(export))
+	for section := range export {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, section := range sections {
+		file, err := archive.Create(section + ".json")
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(export[section]); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// purgeExpired periodically deletes accounts whose grace period is over, revocation entries of expired tokens
+// and spent challenges until the service is closed
+func (serv *authService) purgeExpired() {
+	ticker := time.NewTicker(purgePeriod)
+	defer ticker.Stop()
+	for {
+		if deleted, err := serv.userManager.PurgeDeletedAccounts(); err == nil && deleted > 0 {
+			log.Println("purged deleted accounts:", deleted)
+		}
+		_, _ = serv.userManager.PurgeRevokedTokens()
+		_, _ = serv.challengeManager.Purge()
+		select {
+		case <-ticker.C:
+		case <-serv.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+)
+
+func TestZipExport(t *testing.T) {
+	export := user.Export{
+		"profile":  map[string]interface{}{"email": "ivan@mail.ru"},
+		"sessions": []string{},
+		"audit":    []int{1, 2},
+	}
+	data, err := zipExport(export)
+	if err != nil {
+		t.Fatal("zipExport returns an error:", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal("the archive can't be read:", err)
+	}
+	expected := []string{"audit.json", "profile.json", "sessions.json"}
+	if len(archive.File) != len(expected) {
+		t.Fatalf("got %d files, expected: %d", len(archive.File), len(expected))
+	}
+	for i, file := range archive.File {
+		if file.Name != expected[i] {
+			t.Errorf("got file: %s, expected: %s", file.Name, expected[i])
+		}
+	}
+	reader, err := archive.File[1].Open()
+	if err != nil {
+		t.Fatal("the file can't be opened:", err)
+	}
+	defer reader.Close()
+	content, _ := io.ReadAll(reader)
+	var profile map[string]string
+	if err = json.Unmarshal(content, &profile); err != nil || profile["email"] != "ivan@mail.ru" {
+		t.Errorf("got profile: %s, error: %v", content, err)
+	}
+}
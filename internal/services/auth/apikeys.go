@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func (serv *authService) handleCreateAPIKey(c *gin.Context) {
+	var reqData apiKeyRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	apiKey, key, err := serv.userManager.CreateAPIKey(userClaims.UserID, reqData.Name, reqData.Scopes, reqData.ExpiresAt)
+	if err != nil {
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrTooManyAPIKeys {
+			code = http.StatusConflict
+		} else {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusCreated
+	c.JSON(code, gin.H{
+		"code":    code,
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+func (serv *authService) handleAPIKeys(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	apiKeys, err := serv.userManager.GetAPIKeys(userClaims.UserID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":     code,
+		"api_keys": apiKeys,
+	})
+}
+
+func (serv *authService) handleRevokeAPIKey(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := serv.userManager.RevokeAPIKey(userClaims.UserID, id); err != nil {
+		code := http.StatusNotFound
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/http"
+
+	"github.com/adjsky/fetchapp_server/internal/models/challenge"
+	"github.com/adjsky/fetchapp_server/pkg/pow"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	challengeHeader = "X-PoW-Challenge"
+	solutionHeader  = "X-PoW-Solution"
+)
+
+// challengeKey derives a key challenges are signed with, so that they can't be confused with anything else signed with the secret
+func challengeKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("proof of work challenge"))
+	return mac.Sum(nil)
+}
+
+// challengePolicy returns the difficulty policy with bounds from the config
+func (serv *authService) challengePolicy() challenge.Policy {
+	policy := challenge.DefaultPolicy
+	policy.BaseDifficulty = serv.config.ProofOfWork.BaseDifficulty
+	policy.MaxDifficulty = serv.config.ProofOfWork.MaxDifficulty
+	return policy
+}
+
+// handleChallenge issues a puzzle whose difficulty grows with the recent number of protected requests.
+// A client solves it by finding a solution such that SHA-256 of "challenge:solution" starts with difficulty zero bits
+func (serv *authService) handleChallenge(c *gin.Context) {
+	difficulty, err := serv.challengeManager.Difficulty(c.ClientIP(), serv.challengePolicy())
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	issued := pow.New(serv.challengeKey, difficulty, challenge.Lifespan)
+	c.Header("Cache-Control", "no-store")
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":       code,
+		"algorithm":  "sha256",
+		"challenge":  issued.Token,
+		"difficulty": issued.Difficulty,
+		"expires_at": issued.ExpiresAt,
+	})
+}
+
+// requireProofOfWork lets through only requests with a solved challenge
+func (serv *authService) requireProofOfWork(c *gin.Context) {
+	if !serv.checkProofOfWork(c) {
+		c.Abort()
+	}
+}
+
+// checkProofOfWork spends a challenge solved in request headers and responds with an error if there's none,
+// it reports whether the request can proceed
+func (serv *authService) checkProofOfWork(c *gin.Context) bool {
+	if !serv.config.ProofOfWork.Enabled {
+		return true
+	}
+	solved, err := pow.Verify(serv.challengeKey, c.GetHeader(challengeHeader), c.GetHeader(solutionHeader))
+	if err == nil {
+		err = serv.challengeManager.Spend(solved.Nonce, c.ClientIP(), solved.ExpiresAt)
+	}
+	if err != nil {
+		code := http.StatusForbidden
+		if err == challenge.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return false
+	}
+	return true
+}
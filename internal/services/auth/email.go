@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adjsky/fetchapp_server/internal/emails"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func (serv *authService) handleEmailChange(c *gin.Context) {
+	var reqData emailChangeRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	if !emailRegex.MatchString(reqData.NewEmail) {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "invalid email address",
+		})
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	changeID, err := serv.userManager.RequestEmailChange(userClaims.UserID, userClaims.SessionID, reqData.Password, reqData.NewEmail)
+	if err != nil {
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrEmailRegistered {
+			code = http.StatusConflict
+		} else {
+			code = http.StatusUnauthorized
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	serv.sendEmailChangeEmails(c, user.FromClaims(userClaims), reqData.NewEmail, changeID)
+	code := http.StatusAccepted
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func (serv *authService) handleEmailConfirm(c *gin.Context) {
+	var reqData emailChangeTokenRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, err := userauth.GetActionClaims(reqData.Token, serv.keys, userauth.ConfirmEmailChangePurpose)
+	if err != nil {
+		respondInvalidEmailChange(c)
+		return
+	}
+	oldEmail, newEmail, err := serv.userManager.ConfirmEmailChange(claims.Id)
+	if err != nil {
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrEmailRegistered {
+			code = http.StatusConflict
+		} else {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	serv.sendMail(c, oldEmail, emails.EmailChanged, emails.Data{
+		Email:    oldEmail,
+		NewEmail: newEmail,
+	})
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":  code,
+		"email": newEmail,
+	})
+}
+
+func (serv *authService) handleEmailCancel(c *gin.Context) {
+	var reqData emailChangeTokenRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, err := userauth.GetActionClaims(reqData.Token, serv.keys, userauth.CancelEmailChangePurpose)
+	if err != nil {
+		respondInvalidEmailChange(c)
+		return
+	}
+	if err = serv.userManager.CancelEmailChange(claims.Id); err != nil {
+		code := http.StatusBadRequest
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// sendEmailChangeEmails sends a confirmation link to the new address and a notice with a cancel link to the old one
+func (serv *authService) sendEmailChangeEmails(c *gin.Context, model *user.Model, newEmail, changeID string) {
+	oldEmail := model.Email
+	changed := *model
+	changed.Email = newEmail
+	confirmToken, err := changed.GetEmailChangeToken(serv.keys, userauth.ConfirmEmailChangePurpose, changeID)
+	if err != nil {
+		log.Println("sendEmailChangeEmails error: " + err.Error())
+		return
+	}
+	cancelToken, err := model.GetEmailChangeToken(serv.keys, userauth.CancelEmailChangePurpose, changeID)
+	if err != nil {
+		log.Println("sendEmailChangeEmails error: " + err.Error())
+		return
+	}
+	serv.sendMail(c, newEmail, emails.EmailChangeConfirm, emails.Data{
+		Email: newEmail,
+		Code:  confirmToken,
+		Link:  serv.link("/email/confirm", confirmToken),
+	})
+	serv.sendMail(c, oldEmail, emails.EmailChangeNotice, emails.Data{
+		Email:    oldEmail,
+		NewEmail: newEmail,
+		Code:     cancelToken,
+		Link:     serv.link("/email/cancel", cancelToken),
+	})
+}
+
+func respondInvalidEmailChange(c *gin.Context) {
+	code := http.StatusBadRequest
+	c.JSON(code, gin.H{
+		"code":    code,
+		"message": "invalid email change token provided",
+	})
+}
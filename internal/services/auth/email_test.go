@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestSendEmailChangeEmails(t *testing.T) {
+	serv, memory := newTestService()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPut, "/email", nil)
+	model := user.New("ivan@mail.ru")
+	model.ID = 1
+	serv.sendEmailChangeEmails(c, model, "petr@mail.ru", "change-id")
+	messages := memory.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("got %d messages, expected: 2", len(messages))
+	}
+	for i, tc := range []struct {
+		to      string
+		purpose string
+		other   string
+	}{
+		{"petr@mail.ru", userauth.ConfirmEmailChangePurpose, userauth.CancelEmailChangePurpose},
+		{"ivan@mail.ru", userauth.CancelEmailChangePurpose, userauth.ConfirmEmailChangePurpose},
+	} {
+		message := messages[i]
+		if message.To[0].Address != tc.to || !message.Sensitive {
+			t.Errorf("got recipient: %s, sensitive: %v, expected: %s", message.To[0].Address, message.Sensitive, tc.to)
+		}
+		token := mailedToken(t, message)
+		claims, err := userauth.GetActionClaims(token, serv.keys, tc.purpose)
+		if err != nil {
+			t.Fatalf("the %s token is invalid: %v", tc.purpose, err)
+		}
+		if claims.Id != "change-id" || claims.UserID != model.ID || claims.Email != tc.to {
+			t.Errorf("got change id: %s, user id: %d, email: %s", claims.Id, claims.UserID, claims.Email)
+		}
+		if _, err = userauth.GetActionClaims(token, serv.keys, tc.other); err == nil {
+			t.Errorf("the %s token is valid for %s", tc.purpose, tc.other)
+		}
+	}
+}
+
+func TestEmailChangeRejectsOtherTokens(t *testing.T) {
+	serv, _ := newTestService()
+	model := user.New("ivan@mail.ru")
+	model.ID = 1
+	confirmToken, err := model.GetEmailChangeToken(serv.keys, userauth.ConfirmEmailChangePurpose, "change-id")
+	if err != nil {
+		t.Fatal("GetEmailChangeToken returns an error:", err)
+	}
+	cancelToken, err := model.GetEmailChangeToken(serv.keys, userauth.CancelEmailChangePurpose, "change-id")
+	if err != nil {
+		t.Fatal("GetEmailChangeToken returns an error:", err)
+	}
+	for _, tc := range []struct {
+		name    string
+		handler gin.HandlerFunc
+		token   string
+	}{
+		{"Confirming with a cancel token", serv.handleEmailConfirm, cancelToken},
+		{"Canceling with a confirm token", serv.handleEmailCancel, confirmToken},
+		{"Confirming with an invalid token", serv.handleEmailConfirm, "invalid token"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/email", bytes.NewBufferString(`{"token": "`+tc.token+`"}`))
+			tc.handler(c)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got: %d, expected: %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/gin-gonic/gin"
+)
+
+// Token types reported by the introspection endpoint
+const (
+	tokenTypeAccess = "access_token"
+	tokenTypeAPIKey = "api_key"
+)
+
+// introspectionResponse is a token description defined by RFC 7662, inactive tokens are described by the active flag only
+type introspectionResponse struct {
+	Active    bool     `json:"active"`
+	Subject   string   `json:"sub,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	TokenID   string   `json:"jti,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	// ImpersonatorID is an id of an administrator acting as the user
+	ImpersonatorID int `json:"imp,omitempty"`
+}
+
+// requireIntrospectionClient authenticates a backend with HTTP basic credentials from the configuration
+func (serv *authService) requireIntrospectionClient(c *gin.Context) {
+	clientID, clientSecret, ok := c.Request.BasicAuth()
+	if ok {
+		secret, known := serv.config.IntrospectionClients[clientID]
+		// hashes have equal lengths, so the comparison time doesn't depend on the secret
+		expected := sha256.Sum256([]byte(secret))
+		provided := sha256.Sum256([]byte(clientSecret))
+		if subtle.ConstantTimeCompare(expected[:], provided[:]) == 1 && known {
+			return
+		}
+	}
+	c.Header("WWW-Authenticate", `Basic realm="introspection"`)
+	code := http.StatusUnauthorized
+	c.AbortWithStatusJSON(code, gin.H{
+		"code":    code,
+		"message": "invalid client credentials provided",
+	})
+}
+
+// handleIntrospect describes an access token or a personal API key passed in the token form parameter
+func (serv *authService) handleIntrospect(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "no token provided",
+		})
+		return
+	}
+	response, err := serv.introspect(token)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// introspect describes a token, an error is returned only if the token state can't be checked
+func (serv *authService) introspect(token string) (*introspectionResponse, error) {
+	var (
+		claims    *userauth.Claims
+		tokenType string
+		err       error
+	)
+	if user.IsAPIKey(token) {
+		claims, err = serv.userManager.GetAPIKeyClaims(token)
+		tokenType = tokenTypeAPIKey
+	} else {
+		claims, err = userauth.GetClaims(token, serv.keys)
+		if err == nil {
+			err = serv.userManager.ValidateToken(claims)
+		}
+		tokenType = tokenTypeAccess
+	}
+	if err == user.ErrInternal {
+		return nil, err
+	}
+	if err != nil {
+		return &introspectionResponse{}, nil
+	}
+	model, err := serv.userManager.GetProfile(claims.UserID)
+	if err == user.ErrInternal {
+		return nil, err
+	}
+	if err != nil {
+		return &introspectionResponse{}, nil
+	}
+	return describeToken(claims, model, tokenType), nil
+}
+
+// describeToken describes an active token of a given type, the user is described by the current profile
+// rather than by claims which may be outdated
+func describeToken(claims *userauth.Claims, model *user.Model, tokenType string) *introspectionResponse {
+	return &introspectionResponse{
+		Active:         true,
+		Subject:        strconv.Itoa(model.ID),
+		Email:          model.Email,
+		Roles:          []string{model.Role},
+		Scope:          strings.Join(claims.Scopes, " "),
+		IssuedAt:       claims.IssuedAt,
+		ExpiresAt:      claims.ExpiresAt,
+		TokenType:      tokenType,
+		SessionID:      claims.SessionID,
+		TokenID:        claims.Id,
+		Issuer:         claims.Issuer,
+		ImpersonatorID: claims.ImpersonatorID,
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequireIntrospectionClient(t *testing.T) {
+	serv, _ := newTestService()
+	serv.config.IntrospectionClients = map[string]string{"grades": "s3cret"}
+	for _, tc := range []struct {
+		name     string
+		clientID string
+		secret   string
+		basic    bool
+		expected int
+	}{
+		{"Known client passes", "grades", "s3cret", true, http.StatusOK},
+		{"Wrong secret is rejected", "grades", "secret", true, http.StatusUnauthorized},
+		{"Unknown client is rejected", "reports", "s3cret", true, http.StatusUnauthorized},
+		{"Unknown client with an empty secret is rejected", "reports", "", true, http.StatusUnauthorized},
+		{"Missing credentials are rejected", "", "", false, http.StatusUnauthorized},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/introspect", nil)
+			if tc.basic {
+				c.Request.SetBasicAuth(tc.clientID, tc.secret)
+			}
+			serv.requireIntrospectionClient(c)
+			if c.IsAborted() != (tc.expected != http.StatusOK) {
+				t.Fatalf("got aborted: %v, expected status: %d", c.IsAborted(), tc.expected)
+			}
+			if tc.expected != http.StatusOK && (w.Code != tc.expected || w.Header().Get("WWW-Authenticate") == "") {
+				t.Errorf("got: %d, expected: %d with a challenge", w.Code, tc.expected)
+			}
+		})
+	}
+}
+
+func TestIntrospectWithoutToken(t *testing.T) {
+	serv, _ := newTestService()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(url.Values{}.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	serv.handleIntrospect(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got: %d, expected: %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDescribeToken(t *testing.T) {
+	claims := userauth.GenerateClaims(1, "old@mail.ru")
+	claims.SessionID = "session-id"
+	claims.ImpersonatorID = 2
+	claims.Scopes = []string{userauth.ScopeEge, "profile"}
+	model := user.New("ivan@mail.ru")
+	model.ID = 1
+	model.Role = userauth.RoleTeacher
+	data, err := json.Marshal(describeToken(claims, model, tokenTypeAccess))
+	if err != nil {
+		t.Fatal("the response can't be encoded:", err)
+	}
+	var response map[string]interface{}
+	_ = json.Unmarshal(data, &response)
+	for field, expected := range map[string]interface{}{
+		"active":     true,
+		"sub":        "1",
+		"email":      "ivan@mail.ru",
+		"roles":      []interface{}{userauth.RoleTeacher},
+		"scope":      userauth.ScopeEge + " profile",
+		"iat":        float64(claims.IssuedAt),
+		"exp":        float64(claims.ExpiresAt),
+		"token_type": tokenTypeAccess,
+		"sid":        "session-id",
+		"jti":        claims.Id,
+		"iss":        claims.Issuer,
+		"imp":        float64(2),
+	} {
+		if got, _ := json.Marshal(response[field]); string(got) != mustMarshal(t, expected) {
+			t.Errorf("%s: got %s, expected %s", field, got, mustMarshal(t, expected))
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/internal/emails"
+	"github.com/adjsky/fetchapp_server/internal/models/lockout"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func (serv *authService) handleUnlock(c *gin.Context) {
+	var reqData unlockRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, err := userauth.GetActionClaims(reqData.Token, serv.keys, userauth.UnlockPurpose)
+	if err != nil {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "invalid unlock token provided",
+		})
+		return
+	}
+	err = serv.lockoutManager.Reset(lockout.AccountKey(claims.Email))
+	if err == nil {
+		err = serv.lockoutManager.Reset(lockout.RestoreKey(claims.Email))
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// respondIfLocked responds with 429 status code if any of the keys or the client address is locked and reports whether it did
+func (serv *authService) respondIfLocked(c *gin.Context, keys ...string) bool {
+	lockedFor, err := serv.lockoutManager.Check(append(keys, lockout.IPKey(c.ClientIP()))...)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return true
+	}
+	if lockedFor == 0 {
+		return false
+	}
+	retryAfter := int(lockedFor / time.Second)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	code := http.StatusTooManyRequests
+	c.JSON(code, gin.H{
+		"code":        code,
+		"message":     "too many failed attempts, try again later",
+		"retry_after": retryAfter,
+	})
+	return true
+}
+
+// registerFailure counts a failed attempt from the client address and for an account key if it's not empty.
+// The account owner receives an unlock link once the key gets locked
+func (serv *authService) registerFailure(c *gin.Context, email, key string) {
+	_, _, _ = serv.lockoutManager.Fail(lockout.IPKey(c.ClientIP()), lockout.IPPolicy)
+	if key == "" {
+		return
+	}
+	failures, _, err := serv.lockoutManager.Fail(key, lockout.AccountPolicy)
+	if err != nil || failures != lockout.AccountPolicy.Threshold {
+		return
+	}
+	if model, err := serv.userManager.Get(email); err == nil {
+		serv.sendUnlockEmail(c, model)
+	}
+}
+
+// sendUnlockEmail notifies an user about a locked account and sends a link unlocking it
+func (serv *authService) sendUnlockEmail(c *gin.Context, model *user.Model) {
+	token, err := model.GetUnlockToken(serv.keys)
+	if err != nil {
+		return
+	}
+	serv.sendMail(c, model.Email, emails.Unlock, emails.Data{
+		Code: token,
+		Link: serv.link("/unlock", token),
+	})
+}
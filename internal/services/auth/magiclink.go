@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adjsky/fetchapp_server/internal/emails"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// handleMagicLink emails a login link. It responds the same way whether the email is registered or not
+// so the endpoint can't be used to find out who has an account
+func (serv *authService) handleMagicLink(c *gin.Context) {
+	var reqData magicLinkRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	if serv.respondIfLocked(c) {
+		return
+	}
+	linkID, userID, err := serv.userManager.CreateMagicLink(reqData.Email)
+	if err == user.ErrInternal {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err == nil {
+		model := user.New(reqData.Email)
+		model.ID = userID
+		serv.sendMagicLinkEmail(c, model, linkID)
+	}
+	code := http.StatusAccepted
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func (serv *authService) handleMagicLinkConsume(c *gin.Context) {
+	var reqData magicLinkConsumeRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, err := userauth.GetActionClaims(reqData.Token, serv.keys, userauth.MagicLinkPurpose)
+	if err != nil {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": user.ErrInvalidMagicLink.Error(),
+		})
+		return
+	}
+	model, err := serv.userManager.ConsumeMagicLink(claims.Id)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	// the link replaces the password only, users with two-factor authentication still have to enter a code
+	if model.TOTPEnabled {
+		serv.respondWithMFAToken(c, model)
+		return
+	}
+	serv.respondWithTokens(c, model, reqData.Device, "magic link")
+}
+
+// sendMagicLinkEmail sends a login link to an user in background
+func (serv *authService) sendMagicLinkEmail(c *gin.Context, model *user.Model, linkID string) {
+	token, err := model.GetMagicLinkToken(serv.keys, linkID)
+	if err != nil {
+		log.Println("sendMagicLinkEmail error: " + err.Error())
+		return
+	}
+	serv.sendMail(c, model.Email, emails.MagicLink, emails.Data{
+		Code: token,
+		Link: serv.link("/magic-link", token),
+	})
+}
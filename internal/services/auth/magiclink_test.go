@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/adjsky/fetchapp_server/config"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
+	"github.com/gin-gonic/gin"
+)
+
+var tokenRegex = regexp.MustCompile(`[\w-]+\.[\w-]+\.[\w-]+`)
+
+// newTestService returns a service sending emails without links into memory, it has no database
+func newTestService() (*authService, *mailer.MemoryMailer) {
+	memory := mailer.NewMemoryMailer()
+	return &authService{
+		config: &config.Config{},
+		keys:   userauth.NewKeyRing([]byte("secret")),
+		mailer: memory,
+	}, memory
+}
+
+// mailedToken returns a token sent in the text of a message
+func mailedToken(t *testing.T, message mailer.Message) string {
+	token := tokenRegex.FindString(message.Text)
+	if token == "" {
+		t.Fatalf("no token in the message: %s", message.Text)
+	}
+	return token
+}
+
+func TestSendMagicLinkEmail(t *testing.T) {
+	serv, memory := newTestService()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/magic-link", nil)
+	model := user.New("ivan@mail.ru")
+	model.ID = 1
+	serv.sendMagicLinkEmail(c, model, "link-id")
+	messages := memory.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, expected: 1", len(messages))
+	}
+	if messages[0].To[0].Address != model.Email || !messages[0].Sensitive {
+		t.Errorf("got recipient: %s, sensitive: %v", messages[0].To[0].Address, messages[0].Sensitive)
+	}
+	token := mailedToken(t, messages[0])
+	claims, err := userauth.GetActionClaims(token, serv.keys, userauth.MagicLinkPurpose)
+	if err != nil {
+		t.Fatal("GetActionClaims returns an error:", err)
+	}
+	if claims.Id != "link-id" || claims.UserID != model.ID {
+		t.Errorf("got link id: %s, user id: %d", claims.Id, claims.UserID)
+	}
+	if _, err = userauth.GetClaims(token, serv.keys); err == nil {
+		t.Error("a magic link token is valid for authentication")
+	}
+}
+
+func TestMagicLinkConsumeRejectsOtherTokens(t *testing.T) {
+	serv, _ := newTestService()
+	model := user.New("ivan@mail.ru")
+	model.ID = 1
+	verificationToken, err := model.GetVerificationToken(serv.keys)
+	if err != nil {
+		t.Fatal("GetVerificationToken returns an error:", err)
+	}
+	for name, token := range map[string]string{
+		"An invalid token":                "invalid token",
+		"A token of another purpose":      verificationToken,
+		"A token signed with another key": signedWithAnotherKey(t, model),
+	} {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodPost, "/magic-link/consume",
+				bytes.NewBufferString(`{"token": "`+token+`"}`))
+			// the manager has no database, so reaching it would panic
+			serv.handleMagicLinkConsume(c)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("got: %d, expected: %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func signedWithAnotherKey(t *testing.T, model *user.Model) string {
+	token, err := model.GetMagicLinkToken(userauth.NewKeyRing([]byte("another secret")), "link-id")
+	if err != nil {
+		t.Fatal("GetMagicLinkToken returns an error:", err)
+	}
+	return token
+}
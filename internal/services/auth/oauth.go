@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/pkg/handlers"
+	"github.com/adjsky/fetchapp_server/pkg/oidc"
+	"github.com/gin-gonic/gin"
+)
+
+func (serv *authService) handleOAuthStart(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, ok := serv.oauthProviders[providerName]
+	if !ok {
+		handlers.NotFound(c)
+		return
+	}
+	verifier := oidc.GenerateVerifier()
+	state, nonce, err := serv.userManager.CreateOAuthState(providerName, verifier)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	authURL, err := provider.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		log.Println("handleOAuthStart error: " + err.Error())
+		code := http.StatusBadGateway
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Redirect(http.StatusFound, authURL)
+}
+
+func (serv *authService) handleOAuthCallback(c *gin.Context) {
+	providerName := c.Param("provider")
+	provider, ok := serv.oauthProviders[providerName]
+	if !ok {
+		handlers.NotFound(c)
+		return
+	}
+	if providerError := c.Query("error"); providerError != "" {
+		code := http.StatusUnauthorized
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "the provider returned an error: " + providerError,
+		})
+		return
+	}
+	verifier, nonce, err := serv.userManager.ConsumeOAuthState(providerName, c.Query("state"))
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	identity, err := provider.Authenticate(c.Query("code"), verifier, nonce)
+	if err != nil {
+		log.Println("handleOAuthCallback error: " + err.Error())
+		code := http.StatusUnauthorized
+		if err == oidc.ErrDiscovery {
+			code = http.StatusBadGateway
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	model, err := serv.userManager.GetByIdentity(providerName, identity.Subject, identity.Email, identity.EmailVerified,
+		!serv.config.InviteOnly)
+	if err != nil {
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrInviteRequired {
+			code = http.StatusForbidden
+		} else if err == user.ErrIdentityConflict || err == user.ErrUnverifiedAccount {
+			code = http.StatusConflict
+		} else {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	if model.TOTPEnabled {
+		serv.respondWithMFAToken(c, model)
+		return
+	}
+	serv.respondWithTokens(c, model, "", "oauth: "+providerName)
+}
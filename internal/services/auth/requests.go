@@ -1,13 +1,18 @@
 package auth
 
+import "time"
+
 type loginRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Device   string `json:"device"`
 }
 
 type signupRequest struct {
 	Email    string `json:"email" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	Device   string `json:"device"`
+	Invite   string `json:"invite"`
 }
 
 type restoreRequest struct {
@@ -25,3 +30,55 @@ type restoreValidRequest struct {
 type validRequest struct {
 	Token string `json:"token" binding:"required"`
 }
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type verifyRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type totpRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type mfaRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+	Device   string `json:"device"`
+}
+
+type unlockRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// deleteAccountRequest has no password for accounts created through a provider, they sign in through it again instead
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type apiKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type magicLinkRequest struct {
+	Email string `json:"email" binding:"required"`
+}
+
+type magicLinkConsumeRequest struct {
+	Token  string `json:"token" binding:"required"`
+	Device string `json:"device"`
+}
+
+// emailChangeRequest leaves the password empty for accounts without one the same way as deleteAccountRequest
+type emailChangeRequest struct {
+	Password string `json:"password"`
+	NewEmail string `json:"new_email" binding:"required"`
+}
+
+type emailChangeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
@@ -4,15 +4,23 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"regexp"
 	"strings"
-	"sync"
-	"time"
-
-	"github.com/dchest/uniuri"
 
+	"github.com/adjsky/fetchapp_server/internal/emails"
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/adjsky/fetchapp_server/internal/models/challenge"
+	"github.com/adjsky/fetchapp_server/internal/models/lockout"
 	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/password"
+	"github.com/adjsky/fetchapp_server/internal/models/user/policy"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
 	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/adjsky/fetchapp_server/pkg/mailer"
+	"github.com/adjsky/fetchapp_server/pkg/middlewares"
+	"github.com/adjsky/fetchapp_server/pkg/oidc"
 
 	"github.com/adjsky/fetchapp_server/config"
 	"github.com/adjsky/fetchapp_server/internal/services"
@@ -21,67 +29,109 @@ import (
 
 var emailRegex *regexp.Regexp
 
-const (
-	restoreSessionDuration = time.Minute * 15
-	refreshPeriod          = time.Minute * 5
-)
+// auditLogSize is a number of recent security events shown to an user
+const auditLogSize = 50
 
 func init() {
 	emailRegex = regexp.MustCompile(`^\S+@\S+$`)
 }
 
-type restoreSession struct {
-	email     string
-	createdAt time.Time
-}
-
 type authService struct {
-	config          *config.Config
-	database        *sql.DB
-	restoreSessions map[string]restoreSession
-	restoreMutex    sync.RWMutex
-	userManager     *user.Manager
+	config         *config.Config
+	database       *sql.DB
+	keys           *userauth.KeyRing
+	userManager    *user.Manager
+	lockoutManager *lockout.Manager
+	auditManager   *audit.Manager
+	// challengeManager and challengeKey back proof of work required by routes sending emails
+	challengeManager *challenge.Manager
+	challengeKey     []byte
+	mailer           mailer.Mailer
+	mailFrom         mail.Address
+	oauthProviders   map[string]*oidc.Provider
+	authMiddleware   gin.HandlerFunc
+	stop             chan struct{}
 }
 
 // NewService creates a new auth Service
-func NewService(cfg *config.Config, db *sql.DB) services.Service {
-	s := &authService{
-		config:          cfg,
-		database:        db,
-		restoreSessions: make(map[string]restoreSession),
-		userManager:     user.NewManager(db),
-	}
-	go func() {
-		for {
-			time.Sleep(refreshPeriod)
-			s.CheckExpire()
-		}
-	}()
-	return s
+func NewService(cfg *config.Config, db *sql.DB, passwordPolicy *policy.Policy, passwordHasher *password.Hasher,
+	keys *userauth.KeyRing, sender mailer.Mailer) services.Service {
+	oauthProviders := make(map[string]*oidc.Provider)
+	for name, data := range cfg.OAuthProviders {
+		oauthProviders[name] = oidc.NewProvider(oidc.Config{
+			Issuer:       data.Issuer,
+			ClientID:     data.ClientID,
+			ClientSecret: data.ClientSecret,
+			RedirectURL:  data.RedirectURL,
+		})
+	}
+	mailFrom, err := mail.ParseAddress(cfg.Mail.From)
+	if err != nil {
+		mailFrom = &mail.Address{Address: cfg.Mail.From}
+	}
+	userManager := user.NewManager(db, passwordPolicy, passwordHasher)
+	auditManager := audit.NewManager(db)
+	serv := &authService{
+		config:           cfg,
+		database:         db,
+		keys:             keys,
+		userManager:      userManager,
+		lockoutManager:   lockout.NewManager(db),
+		auditManager:     auditManager,
+		challengeManager: challenge.NewManager(db),
+		challengeKey:     challengeKey(cfg.SecretKey),
+		mailer:           sender,
+		mailFrom:         *mailFrom,
+		oauthProviders:   oauthProviders,
+		authMiddleware: userauth.Middleware(keys, userauth.WithValidator(userManager.ValidateToken),
+			userauth.WithFailureHook(auditManager.RecordTokenRejection),
+			userauth.WithImpersonationHook(auditManager.RecordImpersonatedRequest)),
+		stop: make(chan struct{}),
+	}
+	go serv.purgeExpired()
+	return serv
 }
 
 // Register the auth service
 func (serv *authService) Register(r *gin.RouterGroup) {
 	r.POST("/login", serv.handleLogin)
-	r.POST("/signup", serv.handleSignup)
+	r.GET("/challenge", serv.handleChallenge)
+	r.POST("/signup", serv.requireProofOfWork, serv.handleSignup)
 	r.PUT("/restore", serv.handleRestore)
 	r.POST("/restore/valid", serv.handleRestoreValid)
 	r.POST("/valid", serv.handleValid)
+	r.POST("/introspect", serv.requireIntrospectionClient, serv.handleIntrospect)
+	r.POST("/refresh", serv.handleRefresh)
+	r.POST("/logout", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleLogout)
+	r.POST("/verify", serv.handleVerify)
+	r.POST("/verify/resend", serv.authMiddleware, serv.handleVerifyResend)
+	r.POST("/2fa/enroll", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleTOTPEnroll)
+	r.POST("/2fa/confirm", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleTOTPConfirm)
+	r.POST("/2fa/disable", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleTOTPDisable)
+	r.POST("/2fa/verify", serv.handleTOTPVerify)
+	r.GET("/oauth/:provider/start", serv.handleOAuthStart)
+	r.GET("/oauth/:provider/callback", serv.handleOAuthCallback)
+	r.POST("/unlock", serv.handleUnlock)
+	r.GET("/sessions", serv.authMiddleware, serv.handleSessions)
+	r.DELETE("/sessions", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleRevokeAllSessions)
+	r.DELETE("/sessions/:id", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleRevokeSession)
+	r.DELETE("/account", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleDeleteAccount)
+	r.POST("/account/cancel-deletion", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleCancelDeletion)
+	r.GET("/account/export", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleExport)
+	r.PUT("/email", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleEmailChange)
+	r.POST("/email/confirm", serv.handleEmailConfirm)
+	r.POST("/email/cancel", serv.handleEmailCancel)
+	r.POST("/magic-link", serv.requireProofOfWork, serv.handleMagicLink)
+	r.POST("/magic-link/consume", serv.handleMagicLinkConsume)
+	r.POST("/api-keys", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleCreateAPIKey)
+	r.GET("/api-keys", serv.authMiddleware, serv.handleAPIKeys)
+	r.DELETE("/api-keys/:id", serv.authMiddleware, userauth.ForbidImpersonation(), middlewares.EnsureParamIsInt("id"), serv.handleRevokeAPIKey)
+	r.GET("/audit", serv.authMiddleware, serv.handleAuditLog)
 }
 
 // Close does clean up actions on the service
 func (serv *authService) Close() {
-	//
-}
-
-// CheckExpire checks and deletes outdated restore tokens
-func (serv *authService) CheckExpire() {
-	for k, v := range serv.restoreSessions {
-		timePassed := time.Since(v.createdAt)
-		if timePassed.Seconds() >= restoreSessionDuration.Seconds() {
-			delete(serv.restoreSessions, k)
-		}
-	}
+	close(serv.stop)
 }
 
 func (serv *authService) handleLogin(c *gin.Context) {
@@ -90,29 +140,37 @@ func (serv *authService) handleLogin(c *gin.Context) {
 		helpers.RespondInvalidBody(c)
 		return
 	}
+	accountKey := lockout.AccountKey(reqData.Email)
+	if serv.respondIfLocked(c, accountKey) {
+		return
+	}
 	model, err := serv.userManager.MatchPassword(reqData.Email, reqData.Password)
 	if err != nil {
-		code := http.StatusUnauthorized
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrNotMatched {
+			code = http.StatusUnauthorized
+			serv.registerFailure(c, reqData.Email, accountKey)
+		} else {
+			code = http.StatusUnauthorized
+			serv.registerFailure(c, reqData.Email, "")
+		}
+		if code == http.StatusUnauthorized {
+			serv.audit(c, audit.EventLoginFailed, reqData.Email, err.Error())
+		}
 		c.JSON(code, gin.H{
 			"code":    code,
 			"message": err.Error(),
 		})
 		return
 	}
-	token, err := model.GetAuthToken(serv.config.SecretKey)
-	if err != nil {
-		code := http.StatusInternalServerError
-		c.JSON(code, gin.H{
-			"code":    code,
-			"message": err.Error(),
-		})
+	_ = serv.lockoutManager.Reset(accountKey)
+	if model.TOTPEnabled {
+		serv.respondWithMFAToken(c, model)
 		return
 	}
-	code := http.StatusOK
-	c.JSON(code, gin.H{
-		"code":  code,
-		"token": token,
-	})
+	serv.respondWithTokens(c, model, reqData.Device, "password")
 }
 
 func (serv *authService) handleSignup(c *gin.Context) {
@@ -130,13 +188,28 @@ func (serv *authService) handleSignup(c *gin.Context) {
 		})
 		return
 	}
-	model, err := serv.userManager.Create(reqData.Email, reqData.Password)
+	var (
+		model *user.Model
+		err   error
+	)
+	if reqData.Invite != "" {
+		model, err = serv.userManager.CreateWithInvite(reqData.Email, reqData.Password, reqData.Invite)
+	} else if serv.config.InviteOnly {
+		err = user.ErrInviteRequired
+	} else {
+		model, err = serv.userManager.Create(reqData.Email, reqData.Password)
+	}
 	if err != nil {
+		if respondPolicyError(c, err) {
+			return
+		}
 		var code int
 		if err == user.ErrInternal {
 			code = http.StatusInternalServerError
 		} else if err == user.ErrEmailRegistered {
 			code = http.StatusConflict
+		} else if err == user.ErrInviteRequired || err == user.ErrInvalidInvite {
+			code = http.StatusForbidden
 		}
 		c.JSON(code, gin.H{
 			"code":    code,
@@ -144,7 +217,30 @@ func (serv *authService) handleSignup(c *gin.Context) {
 		})
 		return
 	}
-	token, err := model.GetAuthToken(serv.config.SecretKey)
+	serv.audit(c, audit.EventSignup, model.Email, "")
+	serv.sendVerificationEmail(c, model)
+	serv.respondWithTokens(c, model, reqData.Device, "signup")
+}
+
+func (serv *authService) handleRefresh(c *gin.Context) {
+	var reqData refreshRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	model, sessionID, refreshToken, err := serv.userManager.RotateRefreshToken(reqData.RefreshToken, c.ClientIP())
+	if err != nil {
+		code := http.StatusUnauthorized
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	token, err := model.GetAuthToken(serv.keys, sessionID)
 	if err != nil {
 		code := http.StatusInternalServerError
 		c.JSON(code, gin.H{
@@ -155,11 +251,172 @@ func (serv *authService) handleSignup(c *gin.Context) {
 	}
 	code := http.StatusOK
 	c.JSON(code, gin.H{
-		"code":  code,
-		"token": token,
+		"code":          code,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(userauth.AuthTokenLifespan.Seconds()),
+	})
+}
+
+// respondWithTokens starts a new session for a given user and responds with an access and a refresh token,
+// the login is audited along with a method the user authenticated with
+func (serv *authService) respondWithTokens(c *gin.Context, model *user.Model, device, method string) {
+	sessionID, refreshToken, err := serv.userManager.StartSession(model.ID, device, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == user.ErrAccountDisabled || err == user.ErrPasswordResetRequired {
+			code = http.StatusForbidden
+			serv.audit(c, audit.EventLoginFailed, model.Email, method+": "+err.Error())
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	token, err := model.GetAuthToken(serv.keys, sessionID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	serv.audit(c, audit.EventLoginSucceeded, model.Email, method)
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":          code,
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(userauth.AuthTokenLifespan.Seconds()),
 	})
 }
 
+// audit records a security event about an account caused by the client
+func (serv *authService) audit(c *gin.Context, event, email, details string) {
+	entry := audit.NewEntry(c, event, email)
+	entry.Details = details
+	serv.auditManager.Record(entry)
+}
+
+// getActionClaims returns claims of a single purpose token, the user id of legacy tokens is resolved
+func (serv *authService) getActionClaims(token, purpose string) (*userauth.Claims, error) {
+	claims, err := userauth.GetActionClaims(token, serv.keys, purpose)
+	if err == nil {
+		err = serv.userManager.ResolveClaims(claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (serv *authService) handleVerify(c *gin.Context) {
+	var reqData verifyRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, err := serv.getActionClaims(reqData.Token, userauth.VerifyEmailPurpose)
+	if err != nil {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "invalid verification token provided",
+		})
+		return
+	}
+	if err = serv.userManager.VerifyEmail(claims.UserID); err != nil {
+		code := http.StatusBadRequest
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func (serv *authService) handleVerifyResend(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	verified, err := serv.userManager.IsEmailVerified(userClaims.UserID)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	if verified {
+		code := http.StatusConflict
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "email address is already verified",
+		})
+		return
+	}
+	serv.sendVerificationEmail(c, user.FromClaims(userClaims))
+	code := http.StatusAccepted
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// sendVerificationEmail sends a link confirming an user email address in background
+func (serv *authService) sendVerificationEmail(c *gin.Context, model *user.Model) {
+	token, err := model.GetVerificationToken(serv.keys)
+	if err != nil {
+		log.Println("sendVerificationEmail error: " + err.Error())
+		return
+	}
+	serv.sendMail(c, model.Email, emails.Verification, emails.Data{
+		Email: model.Email,
+		Code:  token,
+		Link:  serv.link("/verify", token),
+	})
+}
+
+// sendMail renders a template in the locale preferred by a client and puts it to the mail queue, failures are only logged
+func (serv *authService) sendMail(c *gin.Context, to, template string, data emails.Data) {
+	subject, text, html, err := emails.Render(emails.MatchLocale(c.GetHeader("Accept-Language")), template, data)
+	if err != nil {
+		log.Println("sendMail error: " + err.Error())
+		return
+	}
+	message := &mailer.Message{
+		From:    serv.mailFrom,
+		To:      []mail.Address{{Address: to}},
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+		// codes and links let anyone reading the message act as the user
+		Sensitive: data.Code != "" || data.Link != "",
+	}
+	if err := serv.mailer.Send(message); err != nil {
+		log.Println("sendMail error: " + err.Error())
+	}
+}
+
+// link returns a link to a page of the client application handling a token or an empty string if there's no application
+func (serv *authService) link(path, token string) string {
+	if serv.config.AppURL == "" {
+		return ""
+	}
+	return serv.config.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
 func (serv *authService) handleRestore(c *gin.Context) {
 	if CheckAuthorized(c) {
 		serv.handleRestoreAuth(c)
@@ -174,7 +431,15 @@ func (serv *authService) handleRestoreAuth(c *gin.Context) {
 		helpers.RespondInvalidBody(c)
 		return
 	}
-	model, err := serv.userManager.GetModelFromToken(GetToken(c), serv.config.SecretKey)
+	model, err := serv.userManager.GetModelFromToken(GetToken(c), serv.keys)
+	if err == userauth.ErrImpersonated {
+		code := http.StatusForbidden
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		code := http.StatusBadRequest
 		c.JSON(code, gin.H{
@@ -183,8 +448,11 @@ func (serv *authService) handleRestoreAuth(c *gin.Context) {
 		})
 		return
 	}
-	err = serv.userManager.ChangePassword(model.Email, reqData.OldPassword, reqData.NewPassword)
+	err = serv.userManager.ChangePassword(model.ID, reqData.OldPassword, reqData.NewPassword)
 	if err != nil {
+		if respondPolicyError(c, err) {
+			return
+		}
 		var code int
 		if err == user.ErrNotMatched {
 			code = http.StatusUnauthorized
@@ -197,6 +465,8 @@ func (serv *authService) handleRestoreAuth(c *gin.Context) {
 		})
 		return
 	}
+	serv.audit(c, audit.EventPasswordChanged, model.Email, "")
+	serv.sendMail(c, model.Email, emails.PasswordChanged, emails.Data{Email: model.Email})
 	code := http.StatusOK
 	c.JSON(code, gin.H{
 		"code": code,
@@ -210,6 +480,10 @@ func (serv *authService) handleRestoreNotAuth(c *gin.Context) {
 		return
 	}
 	if reqData.Code == "" {
+		// only requests sending emails need proof of work, checking a code is covered by the lockout
+		if !serv.checkProofOfWork(c) {
+			return
+		}
 		isRegistered := serv.userManager.IsEmailRegistered(reqData.Email)
 		if !isRegistered {
 			code := http.StatusBadRequest
@@ -219,49 +493,45 @@ func (serv *authService) handleRestoreNotAuth(c *gin.Context) {
 			})
 			return
 		}
-		code := uniuri.NewLen(8)
-		serv.restoreMutex.Lock()
-		for k, v := range serv.restoreSessions {
-			if v.email == reqData.Email {
-				delete(serv.restoreSessions, k)
-			}
-		}
-		serv.restoreSessions[code] = restoreSession{
-			email:     reqData.Email,
-			createdAt: time.Now(),
+		code, err := serv.userManager.CreateRestoreCode(reqData.Email)
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			c.JSON(statusCode, gin.H{
+				"code":    statusCode,
+				"message": err.Error(),
+			})
+			return
 		}
-		serv.restoreMutex.Unlock()
+		serv.audit(c, audit.EventRestoreRequested, reqData.Email, "")
+		serv.sendMail(c, reqData.Email, emails.RestoreCode, emails.Data{
+			Email: reqData.Email,
+			Code:  code,
+		})
 		statusCode := http.StatusAccepted
 		c.JSON(statusCode, gin.H{
 			"code": statusCode,
 		})
-		go func() {
-			err := helpers.SendEmail(&serv.config.SMTP,
-				[]string{reqData.Email},
-				[]byte("Subject: Restore account\n"+code))
-			if err != nil {
-				log.Fatal(err)
-			}
-		}()
 	} else {
 		if reqData.NewPassword == "" || reqData.OldPassword == "" {
 			helpers.RespondInvalidBody(c)
 			return
 		}
-		restoreSession, ok := serv.restoreSessions[reqData.Code]
-		if !ok || restoreSession.email != reqData.Email {
-			code := http.StatusBadRequest
-			c.JSON(code, gin.H{
-				"code":    code,
-				"message": "invalid code provided",
-			})
+		restoreKey := lockout.RestoreKey(reqData.Email)
+		if serv.respondIfLocked(c, restoreKey) {
 			return
 		}
-		err := serv.userManager.ChangePassword(reqData.Email, reqData.OldPassword, reqData.NewPassword)
+		err := serv.userManager.RestorePassword(reqData.Email, reqData.Code, reqData.OldPassword, reqData.NewPassword)
 		if err != nil {
+			if respondPolicyError(c, err) {
+				return
+			}
 			var code int
-			if err == user.ErrNotMatched {
+			if err == user.ErrInvalidRestoreCode || err == user.ErrNoUser {
+				code = http.StatusBadRequest
+				serv.registerFailure(c, reqData.Email, restoreKey)
+			} else if err == user.ErrNotMatched {
 				code = http.StatusUnauthorized
+				serv.registerFailure(c, reqData.Email, lockout.AccountKey(reqData.Email))
 			} else if err == user.ErrInternal {
 				code = http.StatusInternalServerError
 			}
@@ -271,7 +541,8 @@ func (serv *authService) handleRestoreNotAuth(c *gin.Context) {
 			})
 			return
 		}
-		delete(serv.restoreSessions, reqData.Code)
+		serv.audit(c, audit.EventRestoreUsed, reqData.Email, "")
+		serv.sendMail(c, reqData.Email, emails.PasswordChanged, emails.Data{Email: reqData.Email})
 		code := http.StatusOK
 		c.JSON(code, gin.H{
 			"code": code,
@@ -279,18 +550,44 @@ func (serv *authService) handleRestoreNotAuth(c *gin.Context) {
 	}
 }
 
+func (serv *authService) handleAuditLog(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	entries, err := serv.auditManager.ForUser(userClaims.UserID, auditLogSize)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":   code,
+		"events": entries,
+	})
+}
+
 func (serv *authService) handleValid(c *gin.Context) {
 	var reqData validRequest
 	if err := c.ShouldBindJSON(&reqData); err != nil {
 		helpers.RespondInvalidBody(c)
 		return
 	}
-	_, err := serv.userManager.GetModelFromToken(reqData.Token, serv.config.SecretKey)
+	claims, err := userauth.GetClaims(reqData.Token, serv.keys)
+	if err == nil {
+		err = serv.userManager.ValidateToken(claims)
+	}
 	code := http.StatusOK
-	c.JSON(code, gin.H{
+	response := gin.H{
 		"code":  code,
 		"valid": err == nil,
-	})
+	}
+	if err != nil {
+		response["reason"] = err.Error()
+	}
+	c.JSON(code, response)
 }
 
 func (serv *authService) handleRestoreValid(c *gin.Context) {
@@ -299,14 +596,44 @@ func (serv *authService) handleRestoreValid(c *gin.Context) {
 		helpers.RespondInvalidBody(c)
 		return
 	}
-	_, ok := serv.restoreSessions[reqData.Code]
+	restoreKey := lockout.RestoreKey(reqData.Email)
+	if serv.respondIfLocked(c, restoreKey) {
+		return
+	}
+	err := serv.userManager.CheckRestoreCode(reqData.Email, reqData.Code)
+	if err == user.ErrInvalidRestoreCode {
+		serv.registerFailure(c, reqData.Email, restoreKey)
+	}
+	if err == user.ErrInternal {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
 	code := http.StatusOK
 	c.JSON(code, gin.H{
 		"code":  code,
-		"valid": ok,
+		"valid": err == nil,
 	})
 }
 
+// respondPolicyError responds with field errors if err is a password policy violation and reports whether it did
+func respondPolicyError(c *gin.Context, err error) bool {
+	validationErr, ok := err.(*policy.ValidationError)
+	if !ok {
+		return false
+	}
+	code := http.StatusBadRequest
+	c.JSON(code, gin.H{
+		"code":    code,
+		"message": validationErr.Error(),
+		"errors":  validationErr.Errors,
+	})
+	return true
+}
+
 // CheckAuthorized checks whether a given request has a bearer token
 func CheckAuthorized(c *gin.Context) bool {
 	authHeader := c.GetHeader("Authorization")
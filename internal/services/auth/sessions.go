@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/gin-gonic/gin"
+)
+
+type sessionResponse struct {
+	user.Session
+	Current bool `json:"current"`
+}
+
+func (serv *authService) handleSessions(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	sessions, err := serv.userManager.GetSessions(userClaims.UserID)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	response := make([]sessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = sessionResponse{
+			Session: session,
+			Current: session.ID == userClaims.SessionID,
+		}
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":     code,
+		"sessions": response,
+	})
+}
+
+func (serv *authService) handleRevokeSession(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	err := serv.userManager.RevokeSession(userClaims.UserID, c.Param("id"))
+	if err != nil {
+		code := http.StatusNotFound
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func (serv *authService) handleRevokeAllSessions(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	if err := serv.userManager.RevokeAllSessions(userClaims.UserID); err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+// handleLogout revokes the access token a request is authenticated with and ends its session
+func (serv *authService) handleLogout(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	if err := serv.userManager.Logout(userClaims); err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
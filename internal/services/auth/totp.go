@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/adjsky/fetchapp_server/internal/models/audit"
+	"github.com/adjsky/fetchapp_server/internal/models/lockout"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+func (serv *authService) handleTOTPEnroll(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	secret, uri, err := serv.userManager.EnrollTOTP(userClaims.UserID)
+	if err != nil {
+		code := http.StatusConflict
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":   code,
+		"secret": secret,
+		"uri":    uri,
+	})
+}
+
+func (serv *authService) handleTOTPConfirm(c *gin.Context) {
+	var reqData totpRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	recoveryCodes, err := serv.userManager.ConfirmTOTP(userClaims.UserID, reqData.Code)
+	if err != nil {
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrTOTPEnabled {
+			code = http.StatusConflict
+		} else {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":           code,
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+func (serv *authService) handleTOTPDisable(c *gin.Context) {
+	var reqData totpRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	err := serv.userManager.DisableTOTP(userClaims.UserID, reqData.Code)
+	if err != nil {
+		code := http.StatusBadRequest
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func (serv *authService) handleTOTPVerify(c *gin.Context) {
+	var reqData mfaRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, err := serv.getActionClaims(reqData.MFAToken, userauth.MFAPurpose)
+	if err != nil {
+		code := http.StatusUnauthorized
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "invalid mfa token provided",
+		})
+		return
+	}
+	accountKey := lockout.AccountKey(claims.Email)
+	if serv.respondIfLocked(c, accountKey) {
+		return
+	}
+	err = serv.userManager.VerifySecondFactor(claims.UserID, reqData.Code)
+	if err != nil {
+		code := http.StatusUnauthorized
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrInvalidTOTPCode {
+			serv.registerFailure(c, claims.Email, accountKey)
+			serv.audit(c, audit.EventLoginFailed, claims.Email, "totp: "+err.Error())
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	model, err := serv.userManager.GetProfile(claims.UserID)
+	if err != nil {
+		code := http.StatusUnauthorized
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	serv.respondWithTokens(c, model, reqData.Device, "totp")
+}
+
+// respondWithMFAToken asks a client to complete the login with a two-factor code
+func (serv *authService) respondWithMFAToken(c *gin.Context, model *user.Model) {
+	mfaToken, err := model.GetMFAToken(serv.keys)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":         code,
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+	})
+}
@@ -1,12 +1,14 @@
 package chat
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/adjsky/fetchapp_server/internal/models/user"
 	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
 
 	"github.com/adjsky/fetchapp_server/internal/services"
@@ -18,6 +20,9 @@ import (
 const (
 	pongWait   = time.Second * 60
 	pingPeriod = pongWait * 2 / 3
+	writeWait  = time.Second * 10
+	// sendBufferSize is how many messages can wait for a client, messages to a client that falls behind are dropped
+	sendBufferSize = 16
 )
 
 var (
@@ -30,20 +35,34 @@ var (
 	}
 )
 
+// clientData describes a connected user, messages to the connection are only written by its writer goroutine
+// since a websocket connection supports one concurrent writer
 type clientData struct {
-	Email string
+	ID   int
+	Name string
+	send chan chatMessage
+}
+
+// chatMessage is a message sent to every client, senders are shown by display names
+type chatMessage struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Text   string `json:"text"`
 }
 
 type chatService struct {
-	clients       map[*websocket.Conn]clientData
+	userManager   *user.Manager
+	clients       map[*websocket.Conn]*clientData
 	clientsSync   sync.RWMutex
-	broadcastChan chan string
+	broadcastChan chan chatMessage
 }
 
-func NewService() services.Service {
+func NewService(db *sql.DB) services.Service {
 	serv := chatService{
-		clients:       make(map[*websocket.Conn]clientData),
-		broadcastChan: make(chan string),
+		// the chat only reads profiles
+		userManager:   user.NewManager(db, nil, nil),
+		clients:       make(map[*websocket.Conn]*clientData),
+		broadcastChan: make(chan chatMessage),
 	}
 	go serv.broadcast()
 	return &serv
@@ -54,15 +73,25 @@ func (serv *chatService) Register(r *gin.RouterGroup) {
 }
 
 func (serv *chatService) Close() {
+	serv.clientsSync.RLock()
+	defer serv.clientsSync.RUnlock()
 	for client := range serv.clients {
 		client.Close()
 	}
 }
 
+// broadcast queues messages to every client without waiting for them to be written,
+// so a stalled client doesn't hold up the chat
 func (serv *chatService) broadcast() {
-	message := <-serv.broadcastChan
-	for client := range serv.clients {
-		client.WriteMessage(websocket.TextMessage, []byte(message))
+	for message := range serv.broadcastChan {
+		serv.clientsSync.RLock()
+		for _, client := range serv.clients {
+			select {
+			case client.send <- message:
+			default:
+			}
+		}
+		serv.clientsSync.RUnlock()
 	}
 }
 
@@ -74,26 +103,44 @@ func (serv *chatService) handleWebsocket(c *gin.Context) {
 	}
 	claims, _ := c.Get(userauth.ClaimsKey)
 	userClaims, _ := claims.(*userauth.Claims)
-	clientEmail := userClaims.Email
-	log.Println("New client:", clientEmail)
-	serv.clientsSync.Lock()
-	serv.clients[conn] = clientData{
-		Email: clientEmail,
+	profile, err := serv.userManager.GetProfile(userClaims.UserID)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	log.Println("New client:", profile.ID)
+	client := &clientData{
+		ID:   profile.ID,
+		Name: profile.Name(),
+		send: make(chan chatMessage, sendBufferSize),
 	}
+	serv.clientsSync.Lock()
+	serv.clients[conn] = client
 	serv.clientsSync.Unlock()
-	go serv.writer(conn)
+	go serv.writer(conn, client.send)
 	serv.reader(conn)
 }
 
-func (serv *chatService) writer(conn *websocket.Conn) {
+// writer writes queued messages and pings to a connection until the reader closes the queue
+func (serv *chatService) writer(conn *websocket.Conn, send <-chan chatMessage) {
 	pingTicker := time.NewTicker(pingPeriod)
 	defer pingTicker.Stop()
 	for {
-		<-pingTicker.C
-		err := conn.WriteMessage(websocket.PingMessage, []byte{})
+		var err error
+		select {
+		case message, ok := <-send:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err = conn.WriteJSON(message)
+		case <-pingTicker.C:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			err = conn.WriteMessage(websocket.PingMessage, []byte{})
+		}
 		if err != nil {
 			conn.SetReadDeadline(time.Now())
-			break
+			return
 		}
 	}
 }
@@ -108,16 +155,25 @@ func (serv *chatService) reader(conn *websocket.Conn) {
 	})
 	for {
 		mType, message, err := conn.ReadMessage()
+		serv.clientsSync.RLock()
+		client := serv.clients[conn]
+		serv.clientsSync.RUnlock()
 		if err != nil {
 			fmt.Println(err)
-			log.Println("Client left:", serv.clients[conn].Email)
+			log.Println("Client left:", client.ID)
 			serv.clientsSync.Lock()
 			delete(serv.clients, conn)
 			serv.clientsSync.Unlock()
+			// broadcast can't reach the client anymore, so the queue can be closed to stop the writer
+			close(client.send)
 			break
 		}
 		if mType == websocket.TextMessage {
-			serv.broadcastChan <- string(message)
+			serv.broadcastChan <- chatMessage{
+				UserID: client.ID,
+				Name:   client.Name,
+				Text:   string(message),
+			}
 		}
 	}
 }
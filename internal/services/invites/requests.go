@@ -0,0 +1,11 @@
+package invites
+
+import "time"
+
+type inviteRequest struct {
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	Group     string     `json:"group"`
+	MaxUses   int        `json:"max_uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,140 @@
+package invites
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/internal/services"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/adjsky/fetchapp_server/pkg/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+type invitesService struct {
+	userManager *user.Manager
+}
+
+// NewService creates the invites service, routes must be protected by the auth middleware and the teacher or admin role
+func NewService(db *sql.DB) services.Service {
+	return &invitesService{
+		// invites are only managed here, signups with them are handled by the auth service
+		userManager: user.NewManager(db, nil, nil),
+	}
+}
+
+// Register invites service in a provided router
+func (serv *invitesService) Register(r *gin.RouterGroup) {
+	r.POST("", serv.handleCreateInvite)
+	r.GET("", serv.handleInvites)
+	r.DELETE("/:id", middlewares.EnsureParamIsInt("id"), serv.handleRevokeInvite)
+	r.GET("/:id/redemptions", middlewares.EnsureParamIsInt("id"), serv.handleRedemptions)
+}
+
+// Close does clean up actions on the service
+func (serv *invitesService) Close() {
+	//
+}
+
+func (serv *invitesService) handleCreateInvite(c *gin.Context) {
+	var reqData inviteRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	userClaims := getClaims(c)
+	invite, inviteCode, err := serv.userManager.CreateInvite(userClaims.UserID, userClaims.Role, &user.InviteOptions{
+		Email:     reqData.Email,
+		Role:      reqData.Role,
+		Group:     reqData.Group,
+		MaxUses:   reqData.MaxUses,
+		ExpiresAt: reqData.ExpiresAt,
+	})
+	if err != nil {
+		var code int
+		if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else if err == user.ErrForbiddenRole || err == user.ErrForeignGroup {
+			code = http.StatusForbidden
+		} else {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusCreated
+	c.JSON(code, gin.H{
+		"code":        code,
+		"invite_code": inviteCode,
+		"invite":      invite,
+	})
+}
+
+func (serv *invitesService) handleInvites(c *gin.Context) {
+	userClaims := getClaims(c)
+	invites, err := serv.userManager.GetInvites(userClaims.UserID, userClaims.Role)
+	if err != nil {
+		code := http.StatusInternalServerError
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":    code,
+		"invites": invites,
+	})
+}
+
+func (serv *invitesService) handleRevokeInvite(c *gin.Context) {
+	userClaims := getClaims(c)
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := serv.userManager.RevokeInvite(userClaims.UserID, userClaims.Role, id); err != nil {
+		respondError(c, err)
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
+
+func (serv *invitesService) handleRedemptions(c *gin.Context) {
+	userClaims := getClaims(c)
+	id, _ := strconv.Atoi(c.Param("id"))
+	redemptions, err := serv.userManager.GetRedemptions(userClaims.UserID, userClaims.Role, id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code":        code,
+		"redemptions": redemptions,
+	})
+}
+
+func getClaims(c *gin.Context) *userauth.Claims {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	return userClaims
+}
+
+// respondError responds with 404 status code if an invite isn't found or isn't managed by the user
+func respondError(c *gin.Context, err error) {
+	code := http.StatusNotFound
+	if err == user.ErrInternal {
+		code = http.StatusInternalServerError
+	}
+	c.JSON(code, gin.H{
+		"code":    code,
+		"message": err.Error(),
+	})
+}
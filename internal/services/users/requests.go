@@ -0,0 +1,8 @@
+package users
+
+type profileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Locale      *string `json:"locale"`
+	TimeZone    *string `json:"time_zone"`
+	ExamYear    *int    `json:"exam_year"`
+}
@@ -0,0 +1,246 @@
+package users
+
+import (
+	"bytes"
+	"database/sql"
+	"image/png"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/adjsky/fetchapp_server/config"
+	"github.com/adjsky/fetchapp_server/internal/models/user"
+	"github.com/adjsky/fetchapp_server/internal/models/user/userauth"
+	"github.com/adjsky/fetchapp_server/internal/services"
+	"github.com/adjsky/fetchapp_server/pkg/handlers"
+	"github.com/adjsky/fetchapp_server/pkg/helpers"
+	"github.com/adjsky/fetchapp_server/pkg/imaging"
+	"github.com/adjsky/fetchapp_server/pkg/middlewares"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxAvatarSize limits an uploaded file in bytes
+	maxAvatarSize = 5 << 20
+	// maxAvatarSide limits dimensions of an uploaded image
+	maxAvatarSide = 4096
+	// avatarSize is a side of a stored avatar
+	avatarSize = 256
+)
+
+type usersService struct {
+	config         *config.Config
+	userManager    *user.Manager
+	authMiddleware gin.HandlerFunc
+}
+
+// profileResponse is a full profile of the requesting user
+type profileResponse struct {
+	*user.Model
+	AvatarURL string `json:"avatar_url"`
+}
+
+// publicProfileResponse is a profile of any user
+type publicProfileResponse struct {
+	*user.PublicProfile
+	AvatarURL string `json:"avatar_url"`
+}
+
+// NewService creates the users service, profile routes of the requesting user are protected by a given middleware
+func NewService(cfg *config.Config, db *sql.DB, authMiddleware gin.HandlerFunc) services.Service {
+	return &usersService{
+		config: cfg,
+		// profiles never involve passwords
+		userManager:    user.NewManager(db, nil, nil),
+		authMiddleware: authMiddleware,
+	}
+}
+
+// Register users service in a provided router
+func (serv *usersService) Register(r *gin.RouterGroup) {
+	r.GET("/me", serv.authMiddleware, serv.handleProfile)
+	r.PATCH("/me", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleUpdateProfile)
+	r.PUT("/me/avatar", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleUploadAvatar)
+	r.DELETE("/me/avatar", serv.authMiddleware, userauth.ForbidImpersonation(), serv.handleDeleteAvatar)
+	r.GET("/:id", middlewares.EnsureParamIsInt("id"), serv.handlePublicProfile)
+	r.GET("/:id/avatar", middlewares.EnsureParamIsInt("id"), serv.handleAvatar)
+}
+
+// Close does clean up actions on the service
+func (serv *usersService) Close() {
+	//
+}
+
+func (serv *usersService) handleProfile(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	model, err := serv.userManager.GetProfile(userClaims.UserID)
+	serv.respondProfile(c, model, err)
+}
+
+func (serv *usersService) handleUpdateProfile(c *gin.Context) {
+	var reqData profileRequest
+	if err := c.ShouldBindJSON(&reqData); err != nil {
+		helpers.RespondInvalidBody(c)
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	model, err := serv.userManager.UpdateProfile(userClaims.UserID, &user.ProfileUpdate{
+		DisplayName: reqData.DisplayName,
+		Locale:      reqData.Locale,
+		TimeZone:    reqData.TimeZone,
+		ExamYear:    reqData.ExamYear,
+	})
+	serv.respondProfile(c, model, err)
+}
+
+func (serv *usersService) handleUploadAvatar(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarSize+1024)
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "no avatar file provided",
+		})
+		return
+	}
+	if fileHeader.Size > maxAvatarSize {
+		code := http.StatusRequestEntityTooLarge
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": "the avatar file is too large",
+		})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+	img, err := imaging.Decode(file, maxAvatarSide)
+	if err != nil {
+		code := http.StatusBadRequest
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	// the image is always re-encoded so nothing but pixels of the upload is ever served
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, imaging.Thumbnail(img, avatarSize)); err != nil {
+		serv.respondInternal(c, "handleUploadAvatar", err)
+		return
+	}
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	if err = serv.userManager.SetAvatar(userClaims.UserID, buf.Bytes()); err != nil {
+		serv.respondProfile(c, nil, err)
+		return
+	}
+	model, err := serv.userManager.GetProfile(userClaims.UserID)
+	serv.respondProfile(c, model, err)
+}
+
+func (serv *usersService) handleDeleteAvatar(c *gin.Context) {
+	claims, _ := c.Get(userauth.ClaimsKey)
+	userClaims, _ := claims.(*userauth.Claims)
+	respondResult(c, serv.userManager.DeleteAvatar(userClaims.UserID))
+}
+
+func (serv *usersService) handlePublicProfile(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	model, err := serv.userManager.GetProfile(id)
+	if err != nil {
+		respondResult(c, err)
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+		"profile": publicProfileResponse{
+			PublicProfile: model.Public(),
+			AvatarURL:     avatarURL(model),
+		},
+	})
+}
+
+func (serv *usersService) handleAvatar(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	data, err := serv.userManager.GetAvatar(id)
+	if err != nil {
+		if err == user.ErrNoAvatar {
+			handlers.NotFound(c)
+			return
+		}
+		respondResult(c, err)
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=300")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, "image/png", data)
+}
+
+// respondProfile responds with a full profile or an error the profile couldn't be got with
+func (serv *usersService) respondProfile(c *gin.Context, model *user.Model, err error) {
+	if err != nil {
+		respondResult(c, err)
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+		"profile": profileResponse{
+			Model:     model,
+			AvatarURL: avatarURL(model),
+		},
+	})
+}
+
+func (serv *usersService) respondInternal(c *gin.Context, caller string, err error) {
+	log.Println(caller + " error: " + err.Error())
+	code := http.StatusInternalServerError
+	c.JSON(code, gin.H{
+		"code":    code,
+		"message": user.ErrInternal.Error(),
+	})
+}
+
+// avatarURL returns a path the avatar of an user is served at or an empty string if there's no avatar
+func avatarURL(model *user.Model) string {
+	if model.Avatar == "" {
+		return ""
+	}
+	// the name changes with every upload, so it busts caches
+	return "/api/users/" + strconv.Itoa(model.ID) + "/avatar?v=" + model.Avatar[:8]
+}
+
+// respondResult responds with 200 status code or with a status matching an error
+func respondResult(c *gin.Context, err error) {
+	if err != nil {
+		var code int
+		if err == user.ErrNoUser {
+			code = http.StatusNotFound
+		} else if err == user.ErrInternal {
+			code = http.StatusInternalServerError
+		} else {
+			code = http.StatusBadRequest
+		}
+		c.JSON(code, gin.H{
+			"code":    code,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := http.StatusOK
+	c.JSON(code, gin.H{
+		"code": code,
+	})
+}
@@ -1,27 +1,26 @@
 package helpers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"io"
 	"mime"
 	"mime/multipart"
 	"net/http"
-	"net/smtp"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 
-	"github.com/adjsky/fetchapp_server/config"
 	"github.com/dchest/uniuri"
 )
 
-// SendEmail sends an email via the smtp protocol
-func SendEmail(smtpData *config.SMTPData, to []string, message []byte) error {
-	auth := smtp.PlainAuth("", smtpData.Mail, smtpData.Password, smtpData.Host)
-	err := smtp.SendMail(smtpData.Host+":"+smtpData.Port, auth, smtpData.Mail, to, message)
-	return err
+// HashToken returns a hex encoded SHA-256 hash of a token, used to store secrets in the database
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
 // ParseBodyPartToJSON parses a given multipart and unmarshalls its content
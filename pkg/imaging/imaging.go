@@ -0,0 +1,89 @@
+// Package imaging decodes untrusted images and makes square thumbnails of them
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"io"
+
+	// register decoders of accepted formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+var (
+	// ErrUnsupportedFormat is returned when an image isn't a PNG, JPEG or GIF
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge is returned when image dimensions exceed the limit
+	ErrTooLarge = errors.New("image dimensions are too large")
+)
+
+// Decode decodes an image checking its dimensions before the pixels are read,
+// so that a small file can't claim a huge canvas
+func Decode(r io.ReadSeeker, maxSide int) (image.Image, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	if config.Width < 1 || config.Height < 1 || config.Width > maxSide || config.Height > maxSide {
+		return nil, ErrTooLarge
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	return img, nil
+}
+
+// Thumbnail crops the central square of an image and scales it to size x size averaging source pixels
+func Thumbnail(src image.Image, size int) *image.NRGBA {
+	bounds := src.Bounds()
+	side := bounds.Dx()
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+	left := bounds.Min.X + (bounds.Dx()-side)/2
+	top := bounds.Min.Y + (bounds.Dy()-side)/2
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		y0, y1 := span(y, size, side)
+		for x := 0; x < size; x++ {
+			x0, x1 := span(x, size, side)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(src.At(left+sx, top+sy)).(color.NRGBA64)
+					// colors are weighted by alpha so transparent pixels don't darken edges
+					r += uint64(c.R) * uint64(c.A)
+					g += uint64(c.G) * uint64(c.A)
+					b += uint64(c.B) * uint64(c.A)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			pixel := color.NRGBA{A: uint8(a / n >> 8)}
+			if a > 0 {
+				pixel.R = uint8(r / a >> 8)
+				pixel.G = uint8(g / a >> 8)
+				pixel.B = uint8(b / a >> 8)
+			}
+			dst.SetNRGBA(x, y, pixel)
+		}
+	}
+	return dst
+}
+
+// span returns a range of source pixels covered by a destination pixel, it's never empty
+func span(i, size, side int) (int, int) {
+	from := i * side / size
+	to := (i + 1) * side / size
+	if to <= from {
+		to = from + 1
+	}
+	return from, to
+}
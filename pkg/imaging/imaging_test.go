@@ -0,0 +1,56 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func encode(t *testing.T, img image.Image) *bytes.Reader {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestDecode(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	if _, err := Decode(encode(t, img), 40); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := Decode(encode(t, img), 39); err != ErrTooLarge {
+		t.Errorf("expected ErrTooLarge, got: %v", err)
+	}
+	if _, err := Decode(bytes.NewReader([]byte("not an image")), 40); err != ErrUnsupportedFormat {
+		t.Errorf("expected ErrUnsupportedFormat, got: %v", err)
+	}
+}
+
+func TestThumbnail(t *testing.T) {
+	// a wide image with red sides and a blue center, the thumbnail must be cropped to the center
+	img := image.NewNRGBA(image.Rect(0, 0, 30, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 30; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= 10 && x < 20 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	for _, size := range []int{5, 10, 16} {
+		thumb := Thumbnail(img, size)
+		if thumb.Bounds() != image.Rect(0, 0, size, size) {
+			t.Errorf("size: %d, unexpected bounds: %v", size, thumb.Bounds())
+		}
+		expected := color.NRGBA{B: 255, A: 255}
+		for _, p := range []image.Point{{0, 0}, {size - 1, size - 1}} {
+			if got := thumb.NRGBAAt(p.X, p.Y); got != expected {
+				t.Errorf("size: %d, pixel %v: got %v, expected %v", size, p, got, expected)
+			}
+		}
+	}
+}
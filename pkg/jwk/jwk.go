@@ -0,0 +1,165 @@
+// Package jwk converts public keys from and to the JSON Web Key format described in RFC 7517
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// ErrUnsupportedKey is returned for key types and curves that can't be converted
+var ErrUnsupportedKey = errors.New("unsupported key type")
+
+// Key is a public JSON Web Key
+type Key struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// Set is a JSON Web Key Set
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// Find returns a key with a given id
+func (set *Set) Find(kid string) (*Key, bool) {
+	for i := range set.Keys {
+		if set.Keys[i].KeyID == kid {
+			return &set.Keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// PublicKey decodes the key into *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+func (key *Key) PublicKey() (crypto.PublicKey, error) {
+	switch key.KeyType {
+	case "RSA":
+		n, err := decodeInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrUnsupportedKey
+		}
+		x, err := decodeInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if key.Curve != "Ed25519" {
+			return nil, ErrUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(key.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid key parameter")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the key which is suitable as a key id
+func (key *Key) Thumbprint() (string, error) {
+	// members are required to be in lexicographic order which maps keep when they are marshaled
+	var members map[string]string
+	switch key.KeyType {
+	case "RSA":
+		members = map[string]string{"e": key.E, "kty": key.KeyType, "n": key.N}
+	case "EC":
+		members = map[string]string{"crv": key.Curve, "kty": key.KeyType, "x": key.X, "y": key.Y}
+	case "OKP":
+		members = map[string]string{"crv": key.Curve, "kty": key.KeyType, "x": key.X}
+	default:
+		return "", ErrUnsupportedKey
+	}
+	data, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return encodeBytes(sum[:]), nil
+}
+
+// FromPublicKey encodes a public key, the id and the algorithm are copied to the key as is
+func FromPublicKey(kid, alg string, publicKey crypto.PublicKey) (Key, error) {
+	key := Key{
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: alg,
+	}
+	switch pub := publicKey.(type) {
+	case *rsa.PublicKey:
+		key.KeyType = "RSA"
+		key.N = encodeBytes(pub.N.Bytes())
+		key.E = encodeBytes(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		key.KeyType = "EC"
+		key.Curve = pub.Curve.Params().Name
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.X = encodeBytes(pad(pub.X.Bytes(), size))
+		key.Y = encodeBytes(pad(pub.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		key.KeyType = "OKP"
+		key.Curve = "Ed25519"
+		key.X = encodeBytes(pub)
+	default:
+		return Key{}, ErrUnsupportedKey
+	}
+	return key, nil
+}
+
+func decodeInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func encodeBytes(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// pad prepends zero bytes since coordinates of an EC key must have the full curve size
+func pad(data []byte, size int) []byte {
+	if len(data) >= size {
+		return data
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(data):], data)
+	return padded
+}
@@ -0,0 +1,35 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// FileMailer writes every message into its own .eml file which mail clients can open
+type FileMailer struct {
+	dir string
+}
+
+// NewFileMailer returns a mailer which writes messages into a given directory, creating it if needed
+func NewFileMailer(dir string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return nil, err
+	}
+	return &FileMailer{
+		dir: dir,
+	}, nil
+}
+
+// Send writes a message into a file named after the time it was sent at
+func (mailer *FileMailer) Send(message *Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + uniuri.NewLen(8) + ".eml"
+	return os.WriteFile(filepath.Join(mailer.dir, name), data, 0660)
+}
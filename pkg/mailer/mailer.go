@@ -0,0 +1,35 @@
+// Package mailer composes RFC 5322 messages and delivers them over SMTP, into files or into memory
+package mailer
+
+import (
+	"errors"
+
+	"github.com/adjsky/fetchapp_server/config"
+)
+
+const (
+	// TransportSMTP delivers messages through an SMTP server
+	TransportSMTP = "smtp"
+	// TransportFile writes messages into a directory as .eml files, useful for local development
+	TransportFile = "file"
+	// TransportMemory keeps messages in memory, useful for tests
+	TransportMemory = "memory"
+)
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(message *Message) error
+}
+
+// New returns a mailer for the transport chosen in the configuration
+func New(data *config.MailData, smtpData *config.SMTPData) (Mailer, error) {
+	switch data.Transport {
+	case TransportSMTP:
+		return NewSMTPMailer(smtpData), nil
+	case TransportFile:
+		return NewFileMailer(data.Dir)
+	case TransportMemory:
+		return NewMemoryMailer(), nil
+	}
+	return nil, errors.New("unknown mail transport: " + data.Transport)
+}
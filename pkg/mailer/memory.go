@@ -0,0 +1,34 @@
+package mailer
+
+import "sync"
+
+// MemoryMailer keeps sent messages so tests can inspect them
+type MemoryMailer struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+// NewMemoryMailer returns an empty in-memory mailer
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+// Send stores a message
+func (mailer *MemoryMailer) Send(message *Message) error {
+	if _, err := message.Bytes(); err != nil {
+		return err
+	}
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	mailer.messages = append(mailer.messages, *message)
+	return nil
+}
+
+// Messages returns messages sent so far in order
+func (mailer *MemoryMailer) Messages() []Message {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+	messages := make([]Message, len(mailer.messages))
+	copy(messages, mailer.messages)
+	return messages
+}
@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+// Message is an email with a plain text body and an optional HTML alternative
+type Message struct {
+	From    mail.Address
+	To      []mail.Address
+	Subject string
+	Text    string
+	HTML    string
+	// Sensitive marks messages carrying codes or links, queues shouldn't keep their bodies once they are given up on
+	Sensitive bool
+}
+
+// Recipients returns bare addresses of the recipients for the SMTP envelope
+func (message *Message) Recipients() []string {
+	recipients := make([]string, len(message.To))
+	for i, to := range message.To {
+		recipients[i] = to.Address
+	}
+	return recipients
+}
+
+// Bytes encodes the message with RFC 5322 headers, a message with an HTML body becomes multipart/alternative
+func (message *Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	to := make([]string, len(message.To))
+	for i := range message.To {
+		to[i] = message.To[i].String()
+	}
+	writeHeader(&buf, "From", message.From.String())
+	writeHeader(&buf, "To", strings.Join(to, ", "))
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", message.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", "<"+uniuri.NewLen(32)+"@"+domain(message.From.Address)+">")
+	writeHeader(&buf, "MIME-Version", "1.0")
+	if message.HTML == "" {
+		writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
+		writeHeader(&buf, "Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, message.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	body := multipart.NewWriter(&buf)
+	writeHeader(&buf, "Content-Type", "multipart/alternative; boundary="+body.Boundary())
+	buf.WriteString("\r\n")
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		// the preferred alternative goes last
+		{"text/plain; charset=utf-8", message.Text},
+		{"text/html; charset=utf-8", message.HTML},
+	}
+	for _, part := range parts {
+		writer, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err = writeQuotedPrintable(writer, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, key, value string) {
+	buf.WriteString(key + ": " + value + "\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	writer := quotedprintable.NewWriter(w)
+	if _, err := writer.Write([]byte(strings.ReplaceAll(content, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return writer.Close()
+}
+
+// domain returns the domain part of an address used to build unique message ids
+func domain(address string) string {
+	if at := strings.LastIndex(address, "@"); at != -1 && at < len(address)-1 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,58 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+)
+
+func TestMessageBytes(t *testing.T) {
+	message := &Message{
+		From:    mail.Address{Name: "fetchapp", Address: "noreply@fetchapp.example"},
+		To:      []mail.Address{{Address: "loh@mail.ru"}},
+		Subject: "Восстановление аккаунта",
+		Text:    "Your code: 1234",
+		HTML:    "<p>Your code: <b>1234</b></p>",
+	}
+	data, err := message.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Headers are encoded",
+		func(t *testing.T) {
+			subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+			if err != nil || subject != message.Subject {
+				t.Errorf("expected subject: %v, got: %v, %v", message.Subject, subject, err)
+			}
+			for _, header := range []string{"From", "To", "Date", "Message-ID"} {
+				if parsed.Header.Get(header) == "" {
+					t.Errorf("expected the %v header to be set", header)
+				}
+			}
+		})
+	t.Run("Body is multipart/alternative with text and HTML parts",
+		func(t *testing.T) {
+			mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+			if err != nil || mediaType != "multipart/alternative" {
+				t.Fatalf("expected multipart/alternative, got: %v, %v", mediaType, err)
+			}
+			reader := multipart.NewReader(parsed.Body, params["boundary"])
+			for _, expected := range []string{message.Text, message.HTML} {
+				part, err := reader.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, _ := io.ReadAll(part)
+				if string(content) != expected {
+					t.Errorf("expected part content: %q, got: %q", expected, content)
+				}
+			}
+		})
+}
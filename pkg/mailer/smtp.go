@@ -0,0 +1,29 @@
+package mailer
+
+import (
+	"net/smtp"
+
+	"github.com/adjsky/fetchapp_server/config"
+)
+
+// SMTPMailer sends messages through an SMTP server authenticating with PLAIN
+type SMTPMailer struct {
+	data config.SMTPData
+}
+
+// NewSMTPMailer returns a mailer which sends messages with given SMTP credentials
+func NewSMTPMailer(data *config.SMTPData) *SMTPMailer {
+	return &SMTPMailer{
+		data: *data,
+	}
+}
+
+// Send sends a message
+func (mailer *SMTPMailer) Send(message *Message) error {
+	data, err := message.Bytes()
+	if err != nil {
+		return err
+	}
+	auth := smtp.PlainAuth("", mailer.data.Mail, mailer.data.Password, mailer.data.Host)
+	return smtp.SendMail(mailer.data.Host+":"+mailer.data.Port, auth, message.From.Address, message.Recipients(), data)
+}
@@ -0,0 +1,312 @@
+// Package oidc implements the OpenID Connect authorization code flow with PKCE for signing in through external providers
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/jwk"
+	"github.com/dchest/uniuri"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	verifierLength  = 64
+	requestTimeout  = time.Second * 10
+	allowedLeeway   = 60
+	discoveryPath   = "/.well-known/openid-configuration"
+	challengeMethod = "S256"
+)
+
+var (
+	// ErrDiscovery is returned when the provider metadata can't be fetched
+	ErrDiscovery = errors.New("can't discover the provider configuration")
+	// ErrExchange is returned when the provider refuses to exchange an authorization code
+	ErrExchange = errors.New("can't exchange the authorization code")
+	// ErrInvalidIDToken is returned when an id token fails validation
+	ErrInvalidIDToken = errors.New("an invalid id token received")
+)
+
+// Config holds client registration data of a provider
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is an user identity asserted by a provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	Error       string `json:"error"`
+}
+
+// Provider is an OpenID Connect provider client, its metadata is discovered on first use
+type Provider struct {
+	config     Config
+	httpClient *http.Client
+	mutex      sync.Mutex
+	metadata   *metadata
+	keys       *jwk.Set
+}
+
+// NewProvider returns a provider client
+func NewProvider(cfg Config) *Provider {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email"}
+	}
+	return &Provider{
+		config:     cfg,
+		httpClient: &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// GenerateVerifier returns a random PKCE code verifier
+func GenerateVerifier() string {
+	return uniuri.NewLen(verifierLength)
+}
+
+// Challenge returns a S256 PKCE code challenge for a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns an address of the provider login page a client should be redirected to
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", Challenge(verifier))
+	params.Set("code_challenge_method", challengeMethod)
+	separator := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return meta.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Authenticate exchanges an authorization code and returns the identity from a validated id token
+func (p *Provider) Authenticate(code, verifier, nonce string) (*Identity, error) {
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", verifier)
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, ErrExchange
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, ErrExchange
+	}
+	defer resp.Body.Close()
+	var tokens tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&tokens); err != nil || resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, ErrExchange
+	}
+	return p.verifyIDToken(tokens.IDToken, nonce)
+}
+
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+}
+
+// Valid checks time based claims, other claims depend on the provider and are checked in verifyIDToken
+func (claims *idTokenClaims) Valid() error {
+	now := time.Now().Unix()
+	if now > claims.ExpiresAt+allowedLeeway {
+		return errors.New("token is expired")
+	}
+	if claims.IssuedAt > now+allowedLeeway {
+		return errors.New("token used before issued")
+	}
+	return nil
+}
+
+// audience is either a single string or an array of strings
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*aud = multiple
+	return nil
+}
+
+func (aud audience) contains(value string) bool {
+	for _, v := range aud {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Provider) verifyIDToken(rawToken, nonce string) (*Identity, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(kid)
+	})
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+	meta, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != meta.Issuer || !claims.Audience.contains(p.config.ClientID) ||
+		claims.Nonce != nonce || claims.Subject == "" {
+		return nil, ErrInvalidIDToken
+	}
+	return &Identity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+	}, nil
+}
+
+// publicKey returns a provider signing key, the key set is fetched again once an unknown key id appears
+func (p *Provider) publicKey(kid string) (interface{}, error) {
+	p.mutex.Lock()
+	keys := p.keys
+	p.mutex.Unlock()
+	key, ok := findKey(keys, kid)
+	if !ok {
+		if err := p.fetchKeys(); err != nil {
+			return nil, err
+		}
+		p.mutex.Lock()
+		keys = p.keys
+		p.mutex.Unlock()
+		if key, ok = findKey(keys, kid); !ok {
+			return nil, errors.New("unknown signing key")
+		}
+	}
+	publicKey, err := key.PublicKey()
+	if err != nil {
+		return nil, err
+	}
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return publicKey, nil
+	}
+	return nil, jwk.ErrUnsupportedKey
+}
+
+func findKey(keys *jwk.Set, kid string) (*jwk.Key, bool) {
+	if keys == nil {
+		return nil, false
+	}
+	if kid == "" && len(keys.Keys) == 1 {
+		return &keys.Keys[0], true
+	}
+	return keys.Find(kid)
+}
+
+func (p *Provider) fetchKeys() error {
+	meta, err := p.discover()
+	if err != nil {
+		return err
+	}
+	var keys jwk.Set
+	if err = p.getJSON(meta.JWKSURI, &keys); err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	p.keys = &keys
+	p.mutex.Unlock()
+	return nil
+}
+
+func (p *Provider) discover() (*metadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	var meta metadata
+	err := p.getJSON(strings.TrimRight(p.config.Issuer, "/")+discoveryPath, &meta)
+	if err != nil || meta.Issuer != p.config.Issuer || meta.AuthorizationEndpoint == "" ||
+		meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, ErrDiscovery
+	}
+	p.metadata = &meta
+	return p.metadata, nil
+}
+
+func (p *Provider) getJSON(address string, v interface{}) error {
+	resp, err := p.httpClient.Get(address)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, address)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
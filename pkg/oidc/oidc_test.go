@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/adjsky/fetchapp_server/pkg/jwk"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	stubClientID = "fetchapp"
+	stubCode     = "authorization-code"
+	stubKeyID    = "stub-key"
+)
+
+// stubServer is a minimal OpenID Connect provider which issues an id token for a single authorization code
+type stubServer struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+}
+
+func newStubServer(t *testing.T) *stubServer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub := &stubServer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(metadata{
+			Issuer:                stub.URL,
+			AuthorizationEndpoint: stub.URL + "/authorize",
+			TokenEndpoint:         stub.URL + "/token",
+			JWKSURI:               stub.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		publicKey, _ := jwk.FromPublicKey(stubKeyID, "RS256", &key.PublicKey)
+		_ = json.NewEncoder(w).Encode(jwk.Set{Keys: []jwk.Key{publicKey}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.PostForm.Get("code") != stubCode || Challenge(r.PostForm.Get("code_verifier")) != stub.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(tokenResponse{Error: "invalid_grant"})
+			return
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":            stub.URL,
+			"sub":            "42",
+			"aud":            []string{stubClientID},
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"iat":            time.Now().Unix(),
+			"nonce":          stub.nonce,
+			"email":          "student@mail.ru",
+			"email_verified": true,
+		})
+		token.Header["kid"] = stubKeyID
+		idToken, _ := token.SignedString(key)
+		_ = json.NewEncoder(w).Encode(tokenResponse{AccessToken: "access", IDToken: idToken})
+	})
+	stub.Server = httptest.NewServer(mux)
+	return stub
+}
+
+// authorize simulates a user approving the login and remembers the parameters sent by the client
+func (stub *stubServer) authorize(t *testing.T, authURL string) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != challengeMethod {
+		t.Fatalf("unexpected code challenge method: %s", query.Get("code_challenge_method"))
+	}
+	stub.challenge = query.Get("code_challenge")
+	stub.nonce = query.Get("nonce")
+}
+
+func TestAuthenticate(t *testing.T) {
+	stub := newStubServer(t)
+	defer stub.Close()
+	provider := NewProvider(Config{
+		Issuer:      stub.URL,
+		ClientID:    stubClientID,
+		RedirectURL: "http://localhost/callback",
+	})
+	t.Run("A valid authorization code returns the provider identity",
+		func(t *testing.T) {
+			verifier := GenerateVerifier()
+			authURL, err := provider.AuthCodeURL("state", "nonce", verifier)
+			if err != nil {
+				t.Fatal("AuthCodeURL returns an error:", err)
+			}
+			stub.authorize(t, authURL)
+			identity, err := provider.Authenticate(stubCode, verifier, "nonce")
+			if err != nil {
+				t.Fatal("Authenticate returns an error:", err)
+			}
+			if identity.Subject != "42" || identity.Email != "student@mail.ru" || !identity.EmailVerified {
+				t.Errorf("unexpected identity: %+v", identity)
+			}
+		})
+	t.Run("A wrong code verifier is rejected by the provider",
+		func(t *testing.T) {
+			authURL, _ := provider.AuthCodeURL("state", "nonce", GenerateVerifier())
+			stub.authorize(t, authURL)
+			_, err := provider.Authenticate(stubCode, GenerateVerifier(), "nonce")
+			if err != ErrExchange {
+				t.Errorf("expected: %v, got: %v", ErrExchange, err)
+			}
+		})
+	t.Run("An id token with a different nonce is rejected",
+		func(t *testing.T) {
+			verifier := GenerateVerifier()
+			authURL, _ := provider.AuthCodeURL("state", "nonce", verifier)
+			stub.authorize(t, authURL)
+			_, err := provider.Authenticate(stubCode, verifier, "another nonce")
+			if err != ErrInvalidIDToken {
+				t.Errorf("expected: %v, got: %v", ErrInvalidIDToken, err)
+			}
+		})
+}
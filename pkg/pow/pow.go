@@ -0,0 +1,114 @@
+// Package pow implements hashcash-style proof-of-work challenges. A challenge is a signed token carrying
+// a difficulty, a solution is any string whose SHA-256 hash of "token:solution" starts with that many zero bits
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
+
+const (
+	nonceLength = 16
+	// maxSolutionLength limits hashed input, any solution fits in a decimal counter far below it
+	maxSolutionLength = 64
+)
+
+var (
+	// ErrInvalidChallenge is returned when a challenge isn't signed with the key or is malformed
+	ErrInvalidChallenge = errors.New("invalid challenge provided")
+	// ErrExpiredChallenge is returned when a challenge is too old
+	ErrExpiredChallenge = errors.New("the challenge has expired")
+	// ErrInvalidSolution is returned when a solution hash doesn't have enough leading zero bits
+	ErrInvalidSolution = errors.New("invalid challenge solution provided")
+)
+
+// Challenge is a puzzle a client has to solve before calling a protected route
+type Challenge struct {
+	Token      string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Nonce identifies the challenge so it can be spent only once
+	Nonce string `json:"-"`
+}
+
+// New issues a challenge signed with a key
+func New(key []byte, difficulty int, lifespan time.Duration) *Challenge {
+	challenge := &Challenge{
+		Difficulty: difficulty,
+		ExpiresAt:  time.Now().Add(lifespan).Truncate(time.Second),
+		Nonce:      uniuri.NewLen(nonceLength),
+	}
+	payload := challenge.Nonce + "." + strconv.Itoa(difficulty) + "." + strconv.FormatInt(challenge.ExpiresAt.Unix(), 10)
+	challenge.Token = payload + "." + sign(key, payload)
+	return challenge
+}
+
+// Verify checks a solution of a challenge token and returns the challenge
+func Verify(key []byte, token, solution string) (*Challenge, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return nil, ErrInvalidChallenge
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(sign(key, payload))) {
+		return nil, ErrInvalidChallenge
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	challenge := &Challenge{
+		Token:      token,
+		Difficulty: difficulty,
+		ExpiresAt:  time.Unix(expiresAt, 0),
+		Nonce:      parts[0],
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrExpiredChallenge
+	}
+	if len(solution) == 0 || len(solution) > maxSolutionLength || LeadingZeroBits(token, solution) < difficulty {
+		return nil, ErrInvalidSolution
+	}
+	return challenge, nil
+}
+
+// Solve finds a solution of a challenge by brute force the way clients do
+func Solve(token string, difficulty int) string {
+	for counter := 0; ; counter++ {
+		solution := strconv.Itoa(counter)
+		if LeadingZeroBits(token, solution) >= difficulty {
+			return solution
+		}
+	}
+}
+
+// LeadingZeroBits returns a number of leading zero bits of the hash of a solution
+func LeadingZeroBits(token, solution string) int {
+	hash := sha256.Sum256([]byte(token + ":" + solution))
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,60 @@
+package pow
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	key := []byte("secret")
+	challenge := New(key, 8, time.Minute)
+	solution := Solve(challenge.Token, challenge.Difficulty)
+	verified, err := Verify(key, challenge.Token, solution)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if verified.Nonce != challenge.Nonce || verified.Difficulty != 8 {
+		t.Errorf("unexpected challenge: %+v", verified)
+	}
+
+	// a counter whose hash misses the difficulty
+	wrong := 0
+	for LeadingZeroBits(challenge.Token, strconv.Itoa(wrong)) >= 8 {
+		wrong++
+	}
+	if _, err = Verify(key, challenge.Token, strconv.Itoa(wrong)); err != ErrInvalidSolution {
+		t.Errorf("expected ErrInvalidSolution, got: %v", err)
+	}
+	if _, err = Verify([]byte("other"), challenge.Token, solution); err != ErrInvalidChallenge {
+		t.Errorf("expected ErrInvalidChallenge for another key, got: %v", err)
+	}
+	// lowering the difficulty breaks the signature
+	forged := challenge.Nonce + ".0." + strconv.FormatInt(challenge.ExpiresAt.Unix(), 10) + "." +
+		sign([]byte("other"), "")
+	if _, err = Verify(key, forged, "0"); err != ErrInvalidChallenge {
+		t.Errorf("expected ErrInvalidChallenge for a forged token, got: %v", err)
+	}
+	expired := New(key, 0, -time.Second)
+	if _, err = Verify(key, expired.Token, "0"); err != ErrExpiredChallenge {
+		t.Errorf("expected ErrExpiredChallenge, got: %v", err)
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	for _, tc := range []struct {
+		token, solution string
+		expected        int
+	}{
+		// sha256("a:1") starts with 0x2b
+		{"a", "1", 2},
+		// sha256("a:63") starts with 0x00 0x07
+		{"a", "63", 13},
+		// sha256("token:2524") starts with 0x00 0x0c
+		{"token", "2524", 12},
+	} {
+		if got := LeadingZeroBits(tc.token, tc.solution); got != tc.expected {
+			t.Errorf("%s:%s: got %d, expected %d", tc.token, tc.solution, got, tc.expected)
+		}
+	}
+}
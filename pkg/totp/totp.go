@@ -0,0 +1,108 @@
+// Package totp implements time-based one-time passwords described in RFC 6238
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period is a time step length in seconds
+	Period = 30
+	// Digits is a number of digits in a generated code
+	Digits = 6
+	// Skew is a number of steps before and after the current one a code is still accepted in
+	Skew = 1
+
+	secretSize = 20
+)
+
+var (
+	// ErrInvalidSecret is returned when a secret isn't a valid base32 string
+	ErrInvalidSecret = errors.New("invalid totp secret")
+
+	encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret returns a new random base32 encoded secret
+func GenerateSecret() (string, error) {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(secret), nil
+}
+
+// URI returns an otpauth key URI which authenticator apps use to enroll a secret
+func URI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns a time step number a given time belongs to
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code returns a code for a given secret at a given time
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return codeAt(key, Step(t)), nil
+}
+
+// Validate checks a code against a given secret at a given time and returns the time step the code matched
+func Validate(secret, code string, t time.Time) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(codeAt(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// codeAt computes a HOTP value (RFC 4226) for a given counter
+func codeAt(key []byte, counter int64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%modulo)
+}
@@ -0,0 +1,74 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed used by the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B lists 8 digit codes, a 6 digit code is their suffix
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := Code(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatal("Code returns an error:", err)
+		}
+		if code != expected {
+			t.Errorf("time: %d, got: %s, expected: %s", unix, code, expected)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal("GenerateSecret returns an error:", err)
+	}
+	now := time.Now()
+	t.Run("A code from the previous step is accepted",
+		func(t *testing.T) {
+			code, _ := Code(secret, now.Add(-Period*time.Second))
+			step, ok := Validate(secret, code, now)
+			if !ok {
+				t.Fatal("a code within the allowed skew should be valid")
+			}
+			if step != Step(now)-1 {
+				t.Errorf("got step: %d, expected: %d", step, Step(now)-1)
+			}
+		})
+	t.Run("An outdated code is rejected",
+		func(t *testing.T) {
+			code, _ := Code(secret, now.Add(-Period*3*time.Second))
+			if _, ok := Validate(secret, code, now); ok {
+				t.Error("an outdated code should be not valid, but actually is valid")
+			}
+		})
+	t.Run("A malformed code is rejected",
+		func(t *testing.T) {
+			if _, ok := Validate(secret, "12345", now); ok {
+				t.Error("a short code should be not valid, but actually is valid")
+			}
+		})
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("fetchapp", "user@mail.ru", "SECRET")
+	if !strings.HasPrefix(uri, "otpauth://totp/fetchapp:user@mail.ru?") {
+		t.Errorf("unexpected uri: %s", uri)
+	}
+	if !strings.Contains(uri, "secret=SECRET") {
+		t.Errorf("uri doesn't contain the secret: %s", uri)
+	}
+}